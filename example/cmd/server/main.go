@@ -1,6 +1,8 @@
 package main
 
 import (
+	"fmt"
+
 	"github.com/happyhakka/grpc-wrapper/example/order"
 	"github.com/happyhakka/grpc-wrapper/grpc"
 )
@@ -10,5 +12,7 @@ func main() {
 	s := grpc.NewGrpcServeWrapper()
 	s.Init("order-service", "6066")
 	order.RegisterOrderServiceServer(s.GetServer(), new(order.OrderServiceImpl))
-	s.Run()
+	if err := s.Run(); err != nil {
+		fmt.Printf("grpc server exit! error<%v>\n", err)
+	}
 }
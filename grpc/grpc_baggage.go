@@ -0,0 +1,42 @@
+package grpc
+
+import (
+	"context"
+
+	. "github.com/happyhakka/grpc-wrapper/log"
+	"github.com/happyhakka/grpc-wrapper/trc"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+//baggageContext 上游传入的baggage超过上限时告警并删除超出的项，不再写入日志、exemplar及传递到下游，
+//配置的baggage写入grpc_ctxtags，调用日志与span会带上该字段
+func baggageContext(ctx context.Context, method string) context.Context {
+	if size, ok := trc.CheckBaggageSize(ctx); !ok {
+		var dropped []string
+		ctx, dropped = trc.TrimBaggage(ctx)
+		Named("trc").Warn("baggage size exceeds limit, items dropped", zap.String("grpc.method", method),
+			zap.Int("size", size), zap.Int("limit", trc.BaggageLimit(ctx)), zap.Strings("dropped", dropped))
+	}
+
+	tags := grpc_ctxtags.Extract(ctx)
+	for _, field := range trc.BaggageFields(ctx) {
+		tags.Set(field.Key, field.Value)
+	}
+	return ctx
+}
+
+//baggageUnaryServerInterceptor 需位于调用链拦截器之后
+func baggageUnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	return handler(baggageContext(ctx, info.FullMethod), req)
+}
+
+//baggageStreamServerInterceptor 需位于调用链拦截器之后
+func baggageStreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	wrapped := grpc_middleware.WrapServerStream(ss)
+	wrapped.WrappedContext = baggageContext(ss.Context(), info.FullMethod)
+	return handler(srv, wrapped)
+}
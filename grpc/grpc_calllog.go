@@ -0,0 +1,36 @@
+package grpc
+
+import (
+	"context"
+	"math"
+	"time"
+
+	. "github.com/happyhakka/grpc-wrapper/log"
+
+	grpc_zap "github.com/grpc-ecosystem/go-grpc-middleware/logging/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+//callLogProducer 按日志配置的CallLogRules决定是否记录grpc调用日志
+var callLogProducer = grpc_zap.MessageProducer(func(ctx context.Context, msg string, level zapcore.Level, code codes.Code, err error, duration zapcore.Field) {
+	method, _ := grpc.Method(ctx)
+	if !ShouldLogCall(method, err, fieldDuration(duration)) {
+		return
+	}
+	grpc_zap.DefaultMessageProducer(ctx, msg, level, code, err, duration)
+})
+
+//fieldDuration 解析grpc_zap的耗时字段(缺省为grpc.time_ms毫秒数)
+func fieldDuration(f zapcore.Field) time.Duration {
+	switch f.Type {
+	case zapcore.Float32Type:
+		return time.Duration(float64(math.Float32frombits(uint32(f.Integer))) * float64(time.Millisecond))
+	case zapcore.Float64Type:
+		return time.Duration(math.Float64frombits(uint64(f.Integer)) * float64(time.Millisecond))
+	case zapcore.DurationType:
+		return time.Duration(f.Integer)
+	}
+	return 0
+}
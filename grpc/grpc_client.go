@@ -1,6 +1,7 @@
 package grpc
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strconv"
@@ -8,11 +9,15 @@ import (
 	"sync"
 	"time"
 
+	. "github.com/happyhakka/grpc-wrapper/log"
 	"github.com/happyhakka/grpc-wrapper/trc"
 
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpc_retry "github.com/grpc-ecosystem/go-grpc-middleware/retry"
 	grpc_opentracing "github.com/grpc-ecosystem/go-grpc-middleware/tracing/opentracing"
 	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 )
@@ -24,6 +29,7 @@ type GrpcPool struct {
 	conns       chan *grpcIdleConn
 	factory     func() (*grpc.ClientConn, error)
 	close       func(*grpc.ClientConn) error
+	release     func() //释放连接池获取的tracer
 }
 
 type grpcIdleConn struct {
@@ -51,6 +57,7 @@ func (c *GrpcPool) Get() (*grpc.ClientConn, error) {
 			if timeout := c.idleTimeout; timeout > 0 {
 				if wrapConn.t.Add(timeout).Before(time.Now()) {
 					//丢弃并关闭该链接
+					Named("pool").Debug("discard idle timeout conn", zap.String("target", wrapConn.conn.Target()))
 					c.close(wrapConn.conn)
 					continue
 				}
@@ -59,14 +66,26 @@ func (c *GrpcPool) Get() (*grpc.ClientConn, error) {
 		default:
 			conn, err := c.factory()
 			if err != nil {
+				Named("pool").Warn("create conn failed", zap.Error(err))
 				return nil, err
 			}
 
+			Named("pool").Debug("no idle conn, create new conn", zap.String("target", conn.Target()))
 			return conn, nil
 		}
 	}
 }
 
+//GetContext 从连接池获取连接，返回的ctx记录获取连接的耗时，使用该ctx调用时写入客户端span(pool.wait_ms)
+func (c *GrpcPool) GetContext(ctx context.Context) (context.Context, *grpc.ClientConn, error) {
+	start := time.Now()
+	conn, err := c.Get()
+	if err != nil {
+		return ctx, nil, err
+	}
+	return withPoolWait(ctx, time.Since(start)), conn, nil
+}
+
 //Put put back to pool
 func (c *GrpcPool) Put(conn *grpc.ClientConn) error {
 	if conn == nil {
@@ -85,6 +104,7 @@ func (c *GrpcPool) Put(conn *grpc.ClientConn) error {
 		return nil
 	default:
 		//连接池已满，直接关闭该链接
+		Named("pool").Debug("pool is full, close conn", zap.String("target", conn.Target()))
 		return c.close(conn)
 	}
 }
@@ -97,6 +117,8 @@ func (c *GrpcPool) Close() {
 	c.factory = nil
 	closeFun := c.close
 	c.close = nil
+	release := c.release
+	c.release = nil
 	c.mu.Unlock()
 
 	if conns == nil {
@@ -107,6 +129,10 @@ func (c *GrpcPool) Close() {
 	for wrapConn := range conns {
 		closeFun(wrapConn.conn)
 	}
+
+	if release != nil {
+		release()
+	}
 }
 
 //IdleCount idle connection count
@@ -123,12 +149,20 @@ var (
 	retryTimes      = 3
 )
 
-func getDefualtDialOption(o *PoolOption) []grpc.DialOption {
+//getDefualtDialOption 默认连接选项，返回的release用于连接池关闭时释放tracer
+func getDefualtDialOption(o *PoolOption) ([]grpc.DialOption, func()) {
 	opts := make([]grpc.DialOption, 0)
-	streamOpts := make([]grpc.DialOption, 0)
+	release := func() {}
+	//grpc.WithUnaryInterceptor多次设置只有最后一次生效，拦截器统一收集后串联
+	interceptors := make([]grpc.UnaryClientInterceptor, 0)
+	streamInterceptors := make([]grpc.StreamClientInterceptor, 0)
 
 	opts = append(opts, grpc.WithInsecure())
 
+	//传递x-request-id
+	interceptors = append(interceptors, requestIDUnaryClientInterceptor)
+	streamInterceptors = append(streamInterceptors, requestIDStreamClientInterceptor)
+
 	if o.PromFlag == false && strings.ToLower(os.Getenv(ENV_PROM_FLAG)) == "on" || strings.ToLower(os.Getenv(ENV_PROM_FLAG)) == "true" {
 		o.PromFlag = true
 	} else if strings.ToLower(os.Getenv(ENV_PROM_FLAG)) == "off" || strings.ToLower(os.Getenv(ENV_PROM_FLAG)) == "false" {
@@ -136,8 +170,13 @@ func getDefualtDialOption(o *PoolOption) []grpc.DialOption {
 	}
 
 	if o.PromFlag {
-		opts = append(opts, grpc.WithUnaryInterceptor(grpc_prometheus.UnaryClientInterceptor))
-		streamOpts = append(streamOpts, grpc.WithStreamInterceptor(grpc_prometheus.StreamClientInterceptor))
+		if o.Metrics != nil {
+			interceptors = append(interceptors, o.Metrics.clientMetrics.UnaryClientInterceptor())
+			streamInterceptors = append(streamInterceptors, o.Metrics.clientMetrics.StreamClientInterceptor())
+		} else {
+			interceptors = append(interceptors, grpc_prometheus.UnaryClientInterceptor)
+			streamInterceptors = append(streamInterceptors, grpc_prometheus.StreamClientInterceptor)
+		}
 	}
 
 	if o.ClientRetryFlag == false && strings.ToLower(os.Getenv(ENV_CLT_RETRY_FLAG)) == "on" || strings.ToLower(os.Getenv(ENV_CLT_RETRY_FLAG)) == "true" {
@@ -166,8 +205,8 @@ func getDefualtDialOption(o *PoolOption) []grpc.DialOption {
 		}
 
 		rto := time.Duration(time.Duration(o.ClientRetryTimeout) * time.Second)
-		opts = append(opts, grpc.WithUnaryInterceptor(grpc_retry.UnaryClientInterceptor(grpc_retry.WithCodes(retriableErrors...), grpc_retry.WithMax(o.ClientRetryTimes), grpc_retry.WithBackoff(grpc_retry.BackoffLinear(rto)))))
-		streamOpts = append(streamOpts, grpc.WithStreamInterceptor(grpc_retry.StreamClientInterceptor(grpc_retry.WithCodes(retriableErrors...), grpc_retry.WithMax(o.ClientRetryTimes), grpc_retry.WithBackoff(grpc_retry.BackoffLinear(rto)))))
+		interceptors = append(interceptors, grpc_retry.UnaryClientInterceptor(grpc_retry.WithCodes(retriableErrors...), grpc_retry.WithMax(o.ClientRetryTimes), grpc_retry.WithBackoff(grpc_retry.BackoffLinear(rto))))
+		streamInterceptors = append(streamInterceptors, grpc_retry.StreamClientInterceptor(grpc_retry.WithCodes(retriableErrors...), grpc_retry.WithMax(o.ClientRetryTimes), grpc_retry.WithBackoff(grpc_retry.BackoffLinear(rto))))
 	}
 
 	if o.TracerFlag == false && strings.ToLower(os.Getenv(ENV_TRC_FLAG)) == "on" || strings.ToLower(os.Getenv(ENV_TRC_FLAG)) == "true" {
@@ -177,22 +216,53 @@ func getDefualtDialOption(o *PoolOption) []grpc.DialOption {
 	}
 
 	if o.TracerFlag {
-		o.TracerAddr = os.Getenv(ENV_TRC_ADDR)
-		if o.TracerAddr == "" {
+		if addr := os.Getenv(ENV_TRC_ADDR); addr != "" {
+			o.TracerAddr = addr
+		}
+		if tt := os.Getenv(ENV_TRC_TYPE); tt != "" {
+			o.TracerType = strings.ToLower(tt)
+		}
+
+		if o.TracerAddr == "" && (o.TracerOption == nil || o.TracerOption.Addr == "" && o.TracerOption.Recorder == nil) {
 			fmt.Println("tracer host addr no found!")
+		} else if to := tracerOption(o.TracerOption, o.ServiceName, o.TracerAddr); isOTel(o.TracerType) {
+			tp, err := trc.InitTracerProvider(to)
+			if err != nil {
+				fmt.Printf("init otel tracer provider fail! error<%v>\n", err)
+			} else {
+				release = func() { trc.ReleaseTracerProvider(to.ServiceName) }
+				handler := otelgrpc.NewClientHandler(otelgrpc.WithTracerProvider(tp), otelgrpc.WithPropagators(trc.TextMapPropagator(to)))
+				opts = append(opts, grpc.WithStatsHandler(spanTagsStatsHandler{handler}))
+				interceptors = append(interceptors, otelCallTagsUnaryClientInterceptor)
+				streamInterceptors = append(streamInterceptors, otelCallTagsStreamClientInterceptor)
+			}
 		} else {
-			tracer, err := trc.InitTracer(o.ServiceName, o.TracerAddr)
+			tracer, err := trc.InitTracerWithOption(to)
 			if err != nil {
 				fmt.Printf("init open tracing fail! error<%v>\n", err)
 			} else {
-				opts = append(opts, grpc.WithUnaryInterceptor(grpc_opentracing.UnaryClientInterceptor(grpc_opentracing.WithTracer(tracer))))
-				streamOpts = append(streamOpts, grpc.WithStreamInterceptor(grpc_opentracing.StreamClientInterceptor(grpc_opentracing.WithTracer(tracer))))
+				release = func() { trc.ReleaseTracer(to.ServiceName) }
+				interceptors = append(interceptors, grpc_opentracing.UnaryClientInterceptor(grpc_opentracing.WithTracer(tracer)))
+				streamInterceptors = append(streamInterceptors, grpc_opentracing.StreamClientInterceptor(grpc_opentracing.WithTracer(tracer)))
+				interceptors = append(interceptors, spanTagsUnaryClientInterceptor)
+				streamInterceptors = append(streamInterceptors, spanTagsStreamClientInterceptor)
 			}
 		}
 	}
-	opts = append(opts, streamOpts...)
+
+	//客户端延迟直方图位于重试与调用链拦截器之后，exemplar关联每次尝试的客户端span
+	if o.PromFlag {
+		hist := clientHandlingSeconds
+		if o.Metrics != nil {
+			hist = o.Metrics.clientHandlingSeconds
+		}
+		interceptors = append(interceptors, handlingUnaryClientInterceptor(hist))
+		streamInterceptors = append(streamInterceptors, handlingStreamClientInterceptor(hist))
+	}
+	opts = append(opts, grpc.WithUnaryInterceptor(grpc_middleware.ChainUnaryClient(interceptors...)))
+	opts = append(opts, grpc.WithStreamInterceptor(grpc_middleware.ChainStreamClient(streamInterceptors...)))
 	opts = append(opts, grpc.WithBlock())
-	return opts
+	return opts, release
 }
 
 //NewGrpcPool init grpc pool
@@ -214,6 +284,11 @@ func NewGrpcPool(o *PoolOption, dialOptions ...grpc.DialOption) (*GrpcPool, erro
 			//ctx, cancel := context.WithTimeout(context.Background(), o.DialTimeout)
 			//defer cancel()
 			//return grpc.DialContext(ctx, target, dialOptions...)
+			if isUnixAddr(target) {
+				//unix socket通过passthrough直接交给unixDialer处理
+				opts := append([]grpc.DialOption{grpc.WithContextDialer(unixDialer)}, dialOptions...)
+				return grpc.Dial("passthrough:///"+target, opts...)
+			}
 			return grpc.Dial(target, dialOptions...)
 		},
 		close:       func(v *grpc.ClientConn) error { return v.Close() },
@@ -236,8 +311,15 @@ func NewGrpcPool(o *PoolOption, dialOptions ...grpc.DialOption) (*GrpcPool, erro
 	return pool, nil
 }
 
-//NewGrpcPoolDefault init grpc pool
-func NewDefaultGrpcPool(o *PoolOption) (*GrpcPool, error) {
-	opts := getDefualtDialOption(o)
-	return NewGrpcPool(o, opts...)
+//NewGrpcPoolDefault init grpc pool, dialOptions追加在默认选项之后
+func NewDefaultGrpcPool(o *PoolOption, dialOptions ...grpc.DialOption) (*GrpcPool, error) {
+	opts, release := getDefualtDialOption(o)
+	opts = append(opts, dialOptions...)
+	pool, err := NewGrpcPool(o, opts...)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	pool.release = release
+	return pool, nil
 }
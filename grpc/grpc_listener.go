@@ -0,0 +1,153 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	unixScheme = "unix:"
+)
+
+//isUnixAddr 是否为unix domain socket地址，如unix:///var/run/order.sock
+func isUnixAddr(addr string) bool {
+	return strings.HasPrefix(addr, unixScheme)
+}
+
+//parseAddr 解析监听/连接地址，返回network与address
+//  unix:///var/run/order.sock -> unix, /var/run/order.sock
+//  unix:order.sock            -> unix, order.sock
+//  6066                       -> tcp, :6066
+func parseAddr(addr string) (string, string) {
+	if isUnixAddr(addr) {
+		path := strings.TrimPrefix(addr, unixScheme)
+		if strings.HasPrefix(path, "//") {
+			path = strings.TrimPrefix(path, "//")
+		}
+		return "unix", path
+	}
+
+	if !strings.Contains(addr, ":") {
+		addr = ":" + addr
+	}
+	return "tcp", addr
+}
+
+//splitAddrs 拆分以逗号分隔的多个监听地址
+func splitAddrs(addrs string) []string {
+	result := make([]string, 0)
+	for _, addr := range strings.Split(addrs, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr != "" {
+			result = append(result, addr)
+		}
+	}
+	return result
+}
+
+//normalizeAddrs 规范化监听地址，仅有端口的tcp地址补全为:port
+func normalizeAddrs(addrs string) string {
+	result := splitAddrs(addrs)
+	for i, addr := range result {
+		if !isUnixAddr(addr) && !strings.Contains(addr, ":") {
+			result[i] = ":" + addr
+		}
+	}
+	return strings.Join(result, ",")
+}
+
+//newListener 创建监听，unix socket会清理残留的socket文件并设置文件权限
+func newListener(addr string, perm os.FileMode) (net.Listener, error) {
+	network, address := parseAddr(addr)
+	if network != "unix" {
+		return net.Listen(network, address)
+	}
+
+	if err := removeStaleSocket(address); err != nil {
+		return nil, err
+	}
+
+	listen, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	if perm != 0 {
+		if err := os.Chmod(address, perm); err != nil {
+			listen.Close()
+			return nil, err
+		}
+	}
+	return listen, nil
+}
+
+//removeStaleSocket 删除上次进程异常退出残留的socket文件，仍在使用中的socket不会删除
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a unix socket", path)
+	}
+
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("unix socket %s is already in use", path)
+	}
+	return os.Remove(path)
+}
+
+//unixDialer grpc客户端连接unix socket
+func unixDialer(ctx context.Context, addr string) (net.Conn, error) {
+	_, address := parseAddr(addr)
+	var d net.Dialer
+	return d.DialContext(ctx, "unix", address)
+}
+
+const (
+	//systemd socket activation传入的第一个文件描述符
+	listenFdsStart = 3
+)
+
+//systemdListeners 获取systemd socket activation通过LISTEN_PID/LISTEN_FDS传入的监听
+func systemdListeners() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	nfds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || nfds <= 0 {
+		return nil, nil
+	}
+
+	//避免子进程重复使用
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make([]net.Listener, 0, nfds)
+	for fd := listenFdsStart; fd < listenFdsStart+nfds; fd++ {
+		file := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		listen, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("systemd listen fd %d: %v", fd, err)
+		}
+		listeners = append(listeners, listen)
+	}
+	return listeners, nil
+}
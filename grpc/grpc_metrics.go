@@ -0,0 +1,181 @@
+package grpc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/happyhakka/grpc-wrapper/trc"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+)
+
+//延迟直方图与grpc_prometheus同名同标签，已有的监控面板无需修改，
+//观测时附加trace_id(及配置的baggage)作为exemplar，可从延迟突增直接跳转到对应的调用链；
+//服务端直方图由每个ServiceMetrics单独创建，客户端直方图在连接池未指定ServiceMetrics时使用默认注册表中的clientHandlingSeconds
+var (
+	clientHandlingSeconds = newClientHandlingSeconds()
+)
+
+func init() {
+	prometheus.MustRegister(clientHandlingSeconds)
+}
+
+func newServerHandlingSeconds() *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "grpc_server_handling_seconds",
+			Help:    "Histogram of response latency (seconds) of gRPC that had been application-level handled by the server.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"grpc_type", "grpc_service", "grpc_method"})
+}
+
+func newClientHandlingSeconds() *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "grpc_client_handling_seconds",
+			Help:    "Histogram of response latency (seconds) of the gRPC until it is finished by the application.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"grpc_type", "grpc_service", "grpc_method"})
+}
+
+const (
+	grpcTypeUnary        = "unary"
+	grpcTypeClientStream = "client_stream"
+	grpcTypeServerStream = "server_stream"
+	grpcTypeBidiStream   = "bidi_stream"
+)
+
+func streamType(clientStream bool, serverStream bool) string {
+	if clientStream && serverStream {
+		return grpcTypeBidiStream
+	} else if clientStream {
+		return grpcTypeClientStream
+	} else if serverStream {
+		return grpcTypeServerStream
+	}
+	return grpcTypeUnary
+}
+
+//observeHandling 记录延迟，ctx中有调用链时附加exemplar
+func observeHandling(ctx context.Context, hist *prometheus.HistogramVec, grpcType string, fullMethod string, start time.Time) {
+	service, method := splitMethodName(fullMethod)
+	observer := hist.WithLabelValues(grpcType, service, method)
+	seconds := time.Since(start).Seconds()
+
+	if labels := trc.ExemplarLabels(ctx); labels != nil {
+		if eo, ok := observer.(prometheus.ExemplarObserver); ok {
+			eo.ObserveWithExemplar(seconds, labels)
+			return
+		}
+	}
+	observer.Observe(seconds)
+}
+
+//handlingUnaryServerInterceptor 服务端延迟直方图，需位于调用链拦截器之后
+func handlingUnaryServerInterceptor(hist *prometheus.HistogramVec) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		observeHandling(ctx, hist, grpcTypeUnary, info.FullMethod, start)
+		return resp, err
+	}
+}
+
+func handlingStreamServerInterceptor(hist *prometheus.HistogramVec) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		observeHandling(ss.Context(), hist, streamType(info.IsClientStream, info.IsServerStream), info.FullMethod, start)
+		return err
+	}
+}
+
+//rpcTraceKey OpenTelemetry客户端span在stats handler中创建，由spanTagsStatsHandler回填带有span的ctx
+type rpcTraceKey struct{}
+
+type rpcTrace struct {
+	ctx context.Context
+}
+
+//withRPCTrace ctx中放入rpcTrace，调用结束后返回带有客户端span的ctx(没有回填时返回原ctx)
+func withRPCTrace(ctx context.Context) (context.Context, func() context.Context) {
+	rt := &rpcTrace{}
+	return context.WithValue(ctx, rpcTraceKey{}, rt), func() context.Context {
+		if rt.ctx != nil {
+			return rt.ctx
+		}
+		return ctx
+	}
+}
+
+//setRPCTrace 在stats handler中回填带有客户端span的ctx
+func setRPCTrace(ctx context.Context) {
+	if rt, ok := ctx.Value(rpcTraceKey{}).(*rpcTrace); ok {
+		rt.ctx = ctx
+	}
+}
+
+//handlingUnaryClientInterceptor 客户端延迟直方图，需位于重试与调用链拦截器之后，每次尝试单独记录
+func handlingUnaryClientInterceptor(hist *prometheus.HistogramVec) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		ctx, traceCtx := withRPCTrace(ctx)
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		observeHandling(traceCtx(), hist, grpcTypeUnary, method, start)
+		return err
+	}
+}
+
+//handlingStreamClientInterceptor 流式调用记录到流结束的时间，流结束指收到EOF或错误、非服务端流收到响应，
+//或调用方提前取消ctx(收到部分消息后放弃的流)，只记录一次
+func handlingStreamClientInterceptor(hist *prometheus.HistogramVec) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		start := time.Now()
+		grpcType := streamType(desc.ClientStreams, desc.ServerStreams)
+		ctx, traceCtx := withRPCTrace(ctx)
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			observeHandling(traceCtx(), hist, grpcType, method, start)
+			return nil, err
+		}
+		hs := &handlingClientStream{ClientStream: cs, serverStreams: desc.ServerStreams, done: make(chan struct{}), finish: func() {
+			observeHandling(traceCtx(), hist, grpcType, method, start)
+		}}
+		//调用方放弃流时需取消ctx，此时记录；不使用cs.Context()，调用后grpc不再透明重试
+		go func() {
+			select {
+			case <-ctx.Done():
+				hs.end()
+			case <-hs.done:
+			}
+		}()
+		return hs, nil
+	}
+}
+
+type handlingClientStream struct {
+	grpc.ClientStream
+	serverStreams bool
+	finish        func()
+	once          sync.Once
+	done          chan struct{}
+}
+
+func (s *handlingClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	//出错(含io.EOF)或非服务端流收到唯一的响应(如CloseAndRecv)时流结束
+	if err != nil || !s.serverStreams {
+		s.end()
+	}
+	return err
+}
+
+//end 记录延迟，只记录一次
+func (s *handlingClientStream) end() {
+	s.once.Do(func() {
+		s.finish()
+		close(s.done)
+	})
+}
@@ -8,6 +8,10 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/happyhakka/grpc-wrapper/trc"
+
+	"google.golang.org/grpc"
 )
 
 const (
@@ -15,19 +19,36 @@ const (
 	ENV_LOG_CONFIG_FILE = "env_log_config_file"
 	ENV_TRC_FLAG        = "env_trc_flag"
 	ENV_TRC_ADDR        = "env_trc_addr"
+	ENV_TRC_TYPE        = "env_trc_type" //调用链实现: opentracing|otel
 	ENV_PROM_FLAG       = "env_prom_flag"
 	ENV_PROM_ADDR       = "env_prom_addr"
+	ENV_PROM_VERSION    = "env_prom_version"  //监控指标service_version标签，服务版本
+	ENV_PROM_INSTANCE   = "env_prom_instance" //监控指标service_instance标签，缺省为主机名
 	ENV_REG_FLAG        = "env_reg_flag"
 	ENV_REG_ADDR        = "env_reg_addr"
 
 	ENV_CLT_RETRY_FLAG    = "env_clt_retry_flag"    //是否开启客户端重启机制
 	ENV_CLT_RETRY_TIMES   = "env_clt_retry_times"   //重试次数
 	ENV_CLT_RETRY_TIMEOUT = "env_clt_retry_timeout" //重试超时
+
+	ENV_GRPC_WEB_FLAG    = "env_grpc_web_flag"    //是否开启grpc-web
+	ENV_GRPC_WEB_MODE    = "env_grpc_web_mode"    //grpc-web服务端口: main|admin
+	ENV_GRPC_WEB_ORIGINS = "env_grpc_web_origins" //grpc-web允许跨域的来源,多个以逗号分隔
+)
+
+const (
+	TRACER_TYPE_OPENTRACING = "opentracing" //jaeger-client-go与opentracing
+	TRACER_TYPE_OTEL        = "otel"        //OpenTelemetry，通过OTLP上报
+)
+
+const (
+	GRPC_WEB_MODE_MAIN  = "main"  //grpc-web与grpc共用服务端口
+	GRPC_WEB_MODE_ADMIN = "admin" //grpc-web与性能监控共用端口
 )
 
 type GrpcSysOption struct {
 	ServiceName string //服务名称ReportService
-	ServiceAddr string //服务监听端口
+	ServiceAddr string //服务监听地址，多个以逗号分隔，支持unix:///path.sock
 	LogFlag     bool   //是否开启日志
 	PromFlag    bool   //性能监控
 	TracerFlag  bool   //是否开启分布式跟踪
@@ -37,6 +58,27 @@ type GrpcSysOption struct {
 	TracerAddr  string //调用链服务地址
 	RegAddr     string //注册中心地址
 	AuthFlag    bool   //是否开启认证功能
+
+	TracerType   string            //调用链实现: opentracing|otel，缺省为opentracing
+	TracerOption *trc.TracerOption //调用链配置(采样方式等)，为空时按ServiceName、TracerAddr及环境变量生成
+
+	PromVersion  string //监控指标service_version标签，缺省为unknown
+	PromInstance string //监控指标service_instance标签，缺省为主机名
+
+	SocketPerm os.FileMode //unix socket文件权限
+
+	GrpcWebFlag    bool     //是否开启grpc-web，供浏览器直接调用
+	GrpcWebMode    string   //grpc-web服务端口: main|admin，缺省为main
+	GrpcWebOrigins []string //grpc-web允许跨域的来源，为空则只允许同源请求，*允许所有来源
+	GrpcWebHeaders []string //grpc-web允许的额外请求头
+
+	PanicDetailFlag bool         //panic时是否将panic信息返回给客户端，缺省返回通用错误
+	RecoveryHook    RecoveryHook //panic回调，如上报崩溃信息
+
+	LogRotateHook func(filename string) //日志按时间滚动后的回调，参数为已完成的日志文件(如转移文件用于日志采集)，为空时使用log.SetRotateHook设置的回调
+
+	UnaryInterceptors  []grpc.UnaryServerInterceptor  //自定义unary拦截器，位于内置拦截器之后
+	StreamInterceptors []grpc.StreamServerInterceptor //自定义stream拦截器，位于内置拦截器之后
 }
 
 func NewGrpcSysOption() *GrpcSysOption {
@@ -53,6 +95,10 @@ func (p *GrpcSysOption) Init() {
 		p.ServiceAddr = ":6066"
 	}
 
+	if p.SocketPerm == 0 {
+		p.SocketPerm = 0660
+	}
+
 	if p.LogFlag == false && (strings.ToLower(os.Getenv(ENV_LOG_FLAG)) == "on" || strings.ToLower(os.Getenv(ENV_LOG_FLAG)) == "true") {
 		p.LogFlag = true
 		p.LogFile = os.Getenv(ENV_LOG_CONFIG_FILE)
@@ -75,6 +121,10 @@ func (p *GrpcSysOption) Init() {
 		}
 	}
 
+	if tt := os.Getenv(ENV_TRC_TYPE); tt != "" {
+		p.TracerType = strings.ToLower(tt)
+	}
+
 	if strings.ToLower(os.Getenv(ENV_PROM_FLAG)) == "off" || strings.ToLower(os.Getenv(ENV_PROM_FLAG)) == "false" {
 		p.PromFlag = false
 	} else {
@@ -84,6 +134,47 @@ func (p *GrpcSysOption) Init() {
 			p.PromAddr = ":5055"
 		}
 	}
+	if version := os.Getenv(ENV_PROM_VERSION); version != "" {
+		p.PromVersion = version
+	}
+	if instance := os.Getenv(ENV_PROM_INSTANCE); instance != "" {
+		p.PromInstance = instance
+	}
+
+	if p.GrpcWebFlag == false && (strings.ToLower(os.Getenv(ENV_GRPC_WEB_FLAG)) == "on" || strings.ToLower(os.Getenv(ENV_GRPC_WEB_FLAG)) == "true") {
+		p.GrpcWebFlag = true
+	}
+
+	if p.GrpcWebFlag {
+		if mode := os.Getenv(ENV_GRPC_WEB_MODE); mode != "" {
+			p.GrpcWebMode = strings.ToLower(mode)
+		}
+		if p.GrpcWebMode == "" {
+			p.GrpcWebMode = GRPC_WEB_MODE_MAIN
+		}
+		if origins := os.Getenv(ENV_GRPC_WEB_ORIGINS); origins != "" {
+			p.GrpcWebOrigins = strings.Split(origins, ",")
+		}
+	}
+}
+
+//isOTel 是否使用OpenTelemetry
+func isOTel(tracerType string) bool {
+	return strings.ToLower(tracerType) == TRACER_TYPE_OTEL
+}
+
+//tracerOption 调用链配置，未设置时按服务名称与调用链服务地址生成
+func tracerOption(o *trc.TracerOption, serviceName string, addr string) *trc.TracerOption {
+	if o == nil {
+		return trc.NewTracerOption(serviceName, addr)
+	}
+	if o.ServiceName == "" {
+		o.ServiceName = serviceName
+	}
+	if o.Addr == "" {
+		o.Addr = addr
+	}
+	return o
 }
 
 var (
@@ -91,6 +182,7 @@ var (
 	errInvalid  = errors.New("invalid config")
 	errRejected = errors.New("connection is nil. rejecting")
 	errTargets  = errors.New("targets server is empty")
+	errNotInit  = errors.New("grpc server is not initialized")
 )
 
 func init() {
@@ -124,6 +216,11 @@ type PoolOption struct {
 	TracerFlag         bool   //是否开启分布式跟踪
 	ServiceName        string //服务名称，用于分布式跟踪
 	TracerAddr         string //分布式跟踪地址
+
+	TracerType   string            //调用链实现: opentracing|otel，缺省为opentracing
+	TracerOption *trc.TracerOption //调用链配置(采样方式等)，为空时按ServiceName、TracerAddr及环境变量生成
+
+	Metrics *ServiceMetrics //客户端指标及延迟直方图所在的服务注册表(如GrpcServeWrapper.Metrics())，为空时使用默认注册表
 }
 
 // Input is the input channel
@@ -0,0 +1,56 @@
+package grpc
+
+import (
+	"context"
+
+	. "github.com/happyhakka/grpc-wrapper/log"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+//payloadUnaryServerInterceptor 按日志配置记录请求与应答内容，需位于grpc_zap之后
+func payloadUnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !ShouldLogPayload(info.FullMethod) {
+		return handler(ctx, req)
+	}
+
+	logger := ctxzap.Extract(ctx)
+	logger.Info("server request payload", zap.String("grpc.request.content", PayloadString(req)))
+	resp, err := handler(ctx, req)
+	if err == nil {
+		logger.Info("server response payload", zap.String("grpc.response.content", PayloadString(resp)))
+	}
+	return resp, err
+}
+
+//payloadStreamServerInterceptor 按日志配置记录流中每条消息的内容
+func payloadStreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if !ShouldLogPayload(info.FullMethod) {
+		return handler(srv, ss)
+	}
+
+	wrapped := &payloadServerStream{WrappedServerStream: grpc_middleware.WrapServerStream(ss)}
+	wrapped.logger = ctxzap.Extract(ss.Context())
+	return handler(srv, wrapped)
+}
+
+type payloadServerStream struct {
+	*grpc_middleware.WrappedServerStream
+	logger *zap.Logger
+}
+
+func (s *payloadServerStream) RecvMsg(m interface{}) error {
+	err := s.WrappedServerStream.RecvMsg(m)
+	if err == nil {
+		s.logger.Info("server request payload", zap.String("grpc.request.content", PayloadString(m)))
+	}
+	return err
+}
+
+func (s *payloadServerStream) SendMsg(m interface{}) error {
+	s.logger.Info("server response payload", zap.String("grpc.response.content", PayloadString(m)))
+	return s.WrappedServerStream.SendMsg(m)
+}
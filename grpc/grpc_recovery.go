@@ -0,0 +1,82 @@
+package grpc
+
+import (
+	"context"
+	"runtime/debug"
+	"strings"
+
+	"github.com/happyhakka/grpc-wrapper/trc"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+//RecoveryHook panic回调(如上报崩溃信息)，返回非空error时替代默认返回给客户端的错误
+type RecoveryHook func(ctx context.Context, p interface{}, stack []byte) error
+
+var (
+	errPanic = status.Error(codes.Internal, "internal server error")
+)
+
+//newPanicsTotal panic计数，由每个ServiceMetrics单独创建
+func newPanicsTotal() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "grpc_server_panics_total",
+			Help: "Total number of panics recovered by the gRPC server.",
+		}, []string{"grpc_service", "grpc_method"})
+}
+
+//recoveryHandler 记录panic日志与监控，缺省返回通用错误避免泄露内部信息
+func (p *GrpcServeWrapper) recoveryHandler(ctx context.Context, r interface{}) error {
+	//recovery拦截器在defer中调用，此时仍处于panic的goroutine上
+	stack := debug.Stack()
+
+	method, _ := grpc.Method(ctx)
+	addr := ""
+	if pr, ok := peer.FromContext(ctx); ok && pr.Addr != nil {
+		addr = pr.Addr.String()
+	}
+	traceID := trc.TraceID(ctx)
+
+	if p.metrics != nil {
+		service, name := splitMethodName(method)
+		p.metrics.panicsTotal.WithLabelValues(service, name).Inc()
+	}
+
+	if p.logger != nil {
+		p.logger.Error("grpc panic recovered",
+			zap.String("grpc.method", method),
+			zap.String("peer.address", addr),
+			zap.String("trace_id", traceID),
+			zap.Any("panic", r),
+			zap.String("stack", string(stack)))
+	} else {
+		grpclog.Errorf("grpc panic recovered! method:%v, peer:%v, trace_id:%v, panic:<%v>\n%s", method, addr, traceID, r, stack)
+	}
+
+	if p.opt.RecoveryHook != nil {
+		if err := p.opt.RecoveryHook(ctx, r, stack); err != nil {
+			return err
+		}
+	}
+
+	if p.opt.PanicDetailFlag {
+		return status.Errorf(codes.Internal, "%v", r)
+	}
+	return errPanic
+}
+
+//splitMethodName /package.Service/Method -> package.Service, Method
+func splitMethodName(fullMethod string) (string, string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	if i := strings.Index(fullMethod, "/"); i >= 0 {
+		return fullMethod[:i], fullMethod[i+1:]
+	}
+	return "unknown", "unknown"
+}
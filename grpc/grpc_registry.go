@@ -0,0 +1,186 @@
+package grpc
+
+import (
+	"os"
+	"sort"
+	"strings"
+
+	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	//go_info已有version标签，instance为prometheus的target标签，常量标签统一加service前缀避免冲突
+	METRIC_LABEL_SERVICE  = "service"          //服务名称
+	METRIC_LABEL_VERSION  = "service_version"  //服务版本
+	METRIC_LABEL_INSTANCE = "service_instance" //服务实例，缺省为主机名
+
+	defaultServiceVersion = "unknown"
+)
+
+//ServiceMetrics 服务独立的prometheus注册表，全部指标附加service、service_version、service_instance常量标签，
+//缺省包含go runtime、进程及内置的grpc指标，/metrics输出该注册表及默认注册表中的其余指标
+type ServiceMetrics struct {
+	namespace  string
+	registry   *prometheus.Registry
+	registerer prometheus.Registerer
+
+	//内置指标，每个服务单独创建，避免多个服务的观测值混在一起
+	serverMetrics         *grpc_prometheus.ServerMetrics
+	clientMetrics         *grpc_prometheus.ClientMetrics
+	serverHandlingSeconds *prometheus.HistogramVec
+	clientHandlingSeconds *prometheus.HistogramVec
+	panicsTotal           *prometheus.CounterVec
+}
+
+//NewServiceMetrics 创建服务注册表，version为空时为unknown，instance为空时使用主机名
+func NewServiceMetrics(serviceName string, version string, instance string) *ServiceMetrics {
+	if version == "" {
+		version = defaultServiceVersion
+	}
+	if instance == "" {
+		instance, _ = os.Hostname()
+	}
+
+	labels := prometheus.Labels{METRIC_LABEL_SERVICE: serviceName, METRIC_LABEL_VERSION: version}
+	if instance != "" {
+		labels[METRIC_LABEL_INSTANCE] = instance
+	}
+
+	m := &ServiceMetrics{}
+	m.namespace = metricNamespace(serviceName)
+	m.registry = prometheus.NewRegistry()
+	m.registerer = prometheus.WrapRegistererWith(labels, m.registry)
+	m.serverMetrics = grpc_prometheus.NewServerMetrics()
+	m.clientMetrics = grpc_prometheus.NewClientMetrics()
+	m.serverHandlingSeconds = newServerHandlingSeconds()
+	m.clientHandlingSeconds = newClientHandlingSeconds()
+	m.panicsTotal = newPanicsTotal()
+
+	m.registerer.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.serverMetrics,
+		m.clientMetrics,
+		m.serverHandlingSeconds,
+		m.clientHandlingSeconds,
+		m.panicsTotal,
+	)
+	return m
+}
+
+//Registry 服务注册表
+func (m *ServiceMetrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+//Registerer 附加常量标签的注册器，自定义collector通过它注册
+func (m *ServiceMetrics) Registerer() prometheus.Registerer {
+	return m.registerer
+}
+
+//Gatherer 服务注册表的指标，及默认注册表中的其他指标(如日志组件指标、未指定ServiceMetrics的连接池指标)
+func (m *ServiceMetrics) Gatherer() prometheus.Gatherer {
+	return serviceGatherer{service: m.registry, fallback: prometheus.DefaultGatherer}
+}
+
+//Namespace 指标名称前缀，服务名称中的非法字符替换为_，如order-service为order_service
+func (m *ServiceMetrics) Namespace() string {
+	return m.namespace
+}
+
+//Counter 在服务命名空间下定义计数器，名称为{namespace}_{name}，同名指标已存在时返回已有的指标
+func (m *ServiceMetrics) Counter(name string, help string, labelNames ...string) *prometheus.CounterVec {
+	c := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: m.namespace,
+		Name:      name,
+		Help:      help,
+	}, labelNames)
+	return m.register(c).(*prometheus.CounterVec)
+}
+
+//Gauge 在服务命名空间下定义gauge
+func (m *ServiceMetrics) Gauge(name string, help string, labelNames ...string) *prometheus.GaugeVec {
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: m.namespace,
+		Name:      name,
+		Help:      help,
+	}, labelNames)
+	return m.register(g).(*prometheus.GaugeVec)
+}
+
+//Histogram 在服务命名空间下定义直方图，buckets为空时使用prometheus.DefBuckets
+func (m *ServiceMetrics) Histogram(name string, help string, buckets []float64, labelNames ...string) *prometheus.HistogramVec {
+	h := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: m.namespace,
+		Name:      name,
+		Help:      help,
+		Buckets:   buckets,
+	}, labelNames)
+	return m.register(h).(*prometheus.HistogramVec)
+}
+
+//register 注册collector，已存在时返回已有的collector，其他错误(如标签不一致)时panic
+func (m *ServiceMetrics) register(c prometheus.Collector) prometheus.Collector {
+	if err := m.registerer.Register(c); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			return are.ExistingCollector
+		}
+		panic(err)
+	}
+	return c
+}
+
+//metricNamespace 替换服务名称中prometheus指标名称不支持的字符
+func metricNamespace(serviceName string) string {
+	ns := strings.Map(func(r rune) rune {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, serviceName)
+	if ns != "" && ns[0] >= '0' && ns[0] <= '9' {
+		ns = "_" + ns
+	}
+	return ns
+}
+
+//serviceGatherer 合并服务注册表与默认注册表，同名指标按样本合并(服务注册表的样本带有service等常量标签，不会重复)，
+//go runtime与进程指标服务注册表已包含，忽略默认注册表中的同名指标
+type serviceGatherer struct {
+	service  prometheus.Gatherer
+	fallback prometheus.Gatherer
+}
+
+func (g serviceGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := g.service.Gather()
+	if err != nil {
+		return mfs, err
+	}
+
+	families := make(map[string]*dto.MetricFamily, len(mfs))
+	for _, mf := range mfs {
+		families[mf.GetName()] = mf
+	}
+	others, err := g.fallback.Gather()
+	for _, mf := range others {
+		existing, ok := families[mf.GetName()]
+		if !ok {
+			mfs = append(mfs, mf)
+			continue
+		}
+		if runtimeMetric(mf.GetName()) || existing.GetType() != mf.GetType() {
+			continue
+		}
+		existing.Metric = append(existing.Metric, mf.GetMetric()...)
+	}
+	sort.Slice(mfs, func(i, j int) bool { return mfs[i].GetName() < mfs[j].GetName() })
+	return mfs, err
+}
+
+//runtimeMetric go runtime与进程指标，服务注册表与默认注册表各有一份
+func runtimeMetric(name string) bool {
+	return strings.HasPrefix(name, "go_") || strings.HasPrefix(name, "process_")
+}
@@ -0,0 +1,112 @@
+package grpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	REQUEST_ID_HEADER = "x-request-id" //请求id的metadata名称
+	REQUEST_ID_TAG    = "request_id"   //请求id在grpc_ctxtags及日志中的字段名
+
+	requestIDMaxLen = 128 //上游请求id的最大长度，超过时生成新的请求id
+)
+
+type requestIDKey struct{}
+
+//WithRequestID 将请求id写入ctx，通过连接池发起的调用会传递给下游服务
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+//RequestID 获取ctx中的请求id
+func RequestID(ctx context.Context) string {
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok {
+		return id
+	}
+	return ""
+}
+
+//newRequestID 生成随机请求id
+func newRequestID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(buf)
+}
+
+//incomingRequestID 从上游metadata获取请求id，没有或不合法时生成新的请求id
+func incomingRequestID(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(REQUEST_ID_HEADER); len(ids) > 0 && validRequestID(ids[0]) {
+			return ids[0]
+		}
+	}
+	return newRequestID()
+}
+
+//validRequestID 上游请求id会写入日志与应答header，只接受不超过requestIDMaxLen的可打印ASCII字符
+func validRequestID(id string) bool {
+	if id == "" || len(id) > requestIDMaxLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7E {
+			return false
+		}
+	}
+	return true
+}
+
+//requestIDContext 请求id写入ctx与grpc_ctxtags，日志会自动带上该字段
+func requestIDContext(ctx context.Context) (context.Context, string) {
+	id := incomingRequestID(ctx)
+	grpc_ctxtags.Extract(ctx).Set(REQUEST_ID_TAG, id)
+	return WithRequestID(ctx, id), id
+}
+
+//requestIDUnaryServerInterceptor 生成或传递x-request-id，并在应答header中返回
+func requestIDUnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, id := requestIDContext(ctx)
+	grpc.SetHeader(ctx, metadata.Pairs(REQUEST_ID_HEADER, id))
+	return handler(ctx, req)
+}
+
+//requestIDStreamServerInterceptor 生成或传递x-request-id，并在应答header中返回
+func requestIDStreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, id := requestIDContext(ss.Context())
+	ss.SetHeader(metadata.Pairs(REQUEST_ID_HEADER, id))
+
+	wrapped := grpc_middleware.WrapServerStream(ss)
+	wrapped.WrappedContext = ctx
+	return handler(srv, wrapped)
+}
+
+//outgoingRequestID 将ctx中的请求id写入下游调用的metadata
+func outgoingRequestID(ctx context.Context) context.Context {
+	id := RequestID(ctx)
+	if id == "" {
+		return ctx
+	}
+	if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get(REQUEST_ID_HEADER)) > 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, REQUEST_ID_HEADER, id)
+}
+
+//requestIDUnaryClientInterceptor 向下游传递x-request-id
+func requestIDUnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return invoker(outgoingRequestID(ctx), method, req, reply, cc, opts...)
+}
+
+//requestIDStreamClientInterceptor 向下游传递x-request-id
+func requestIDStreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return streamer(outgoingRequestID(ctx), desc, cc, method, opts...)
+}
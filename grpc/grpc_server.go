@@ -1,12 +1,13 @@
 package grpc
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"net"
 	"net/http"
-	"runtime"
-	"strings"
+	"sync"
+	"time"
 
 	_ "net/http/pprof"
 
@@ -20,18 +21,35 @@ import (
 	grpc_recovery "github.com/grpc-ecosystem/go-grpc-middleware/recovery"
 	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
 	grpc_opentracing "github.com/grpc-ecosystem/go-grpc-middleware/tracing/opentracing"
-	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
+	"github.com/improbable-eng/grpc-web/go/grpcweb"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/grpclog"
 	"google.golang.org/grpc/reflection"
-	"google.golang.org/grpc/status"
+)
+
+const (
+	httpShutdownTimeout = 5 * time.Second //Stop时等待http请求(grpc-web、性能监控)完成的最长时间
 )
 
 type GrpcServeWrapper struct {
 	svr *grpc.Server
+	web *grpcweb.WrappedGrpcServer
 	opt *GrpcSysOption
+
+	logger *zap.Logger
+
+	releaseTracer func() //释放Init时获取的tracer
+
+	metrics *ServiceMetrics //服务独立的prometheus注册表
+
+	mu        sync.Mutex
+	wg        sync.WaitGroup
+	once      sync.Once
+	err       error
+	listeners []net.Listener
+	httpSvrs  []*http.Server
 }
 
 func NewGrpcServeWrapper() *GrpcServeWrapper {
@@ -46,13 +64,13 @@ func (p *GrpcServeWrapper) SetOption(o *GrpcSysOption) {
 
 func (p *GrpcServeWrapper) Init(serviceName string, serviceAddr string) {
 	p.opt.ServiceName = serviceName
-	p.opt.ServiceAddr = serviceAddr
-	if !strings.Contains(serviceAddr, ":") {
-		p.opt.ServiceAddr = ":" + serviceAddr
-	}
+	p.opt.ServiceAddr = normalizeAddrs(serviceAddr)
 
 	fmt.Printf("grpc-server-option: %#v\n", p.opt)
 
+	//服务独立的prometheus注册表，handler通过Metrics定义业务指标
+	p.metrics = NewServiceMetrics(p.opt.ServiceName, p.opt.PromVersion, p.opt.PromInstance)
+
 	//设置grpc拦截器
 	interceptors := make([]grpc.UnaryServerInterceptor, 0)
 	streamInterceptors := make([]grpc.StreamServerInterceptor, 0)
@@ -60,39 +78,89 @@ func (p *GrpcServeWrapper) Init(serviceName string, serviceAddr string) {
 	streamInterceptors = append(streamInterceptors, grpc_ctxtags.StreamServerInterceptor(grpc_ctxtags.WithFieldExtractor(grpc_ctxtags.CodeGenRequestFieldExtractor)))
 	interceptors = append(interceptors, grpc_ctxtags.UnaryServerInterceptor(grpc_ctxtags.WithFieldExtractor(grpc_ctxtags.CodeGenRequestFieldExtractor)))
 
+	//生成或传递x-request-id，需位于ctxtags之后
+	streamInterceptors = append(streamInterceptors, requestIDStreamServerInterceptor)
+	interceptors = append(interceptors, requestIDUnaryServerInterceptor)
+
+	serverOpts := make([]grpc.ServerOption, 0)
+
 	if p.opt.TracerFlag {
-		//tracer初始化
-		tracer, err := trc.InitTracer(p.opt.ServiceName, p.opt.TracerAddr)
-		if err != nil {
-			grpclog.Errorf("init open tracing fail! error<%v>\n", err)
-			return
+		//同一服务名称的tracer在服务端与连接池间共享，Stop时释放
+		to := tracerOption(p.opt.TracerOption, p.opt.ServiceName, p.opt.TracerAddr)
+		if isOTel(p.opt.TracerType) {
+			//OpenTelemetry通过stats handler创建span，先于所有拦截器执行
+			tp, err := trc.InitTracerProvider(to)
+			if err != nil {
+				grpclog.Errorf("init otel tracer provider fail! error<%v>\n", err)
+				return
+			}
+			p.releaseTracer = func() { trc.ReleaseTracerProvider(to.ServiceName) }
+
+			serverOpts = append(serverOpts, grpc.StatsHandler(otelgrpc.NewServerHandler(otelgrpc.WithTracerProvider(tp), otelgrpc.WithPropagators(trc.TextMapPropagator(to)))))
+		} else {
+			//tracer初始化
+			tracer, err := trc.InitTracerWithOption(to)
+			if err != nil {
+				grpclog.Errorf("init open tracing fail! error<%v>\n", err)
+				return
+			}
+			p.releaseTracer = func() { trc.ReleaseTracer(to.ServiceName) }
+
+			streamInterceptors = append(streamInterceptors, grpc_opentracing.StreamServerInterceptor(grpc_opentracing.WithTracer(tracer)))
+			interceptors = append(interceptors, grpc_opentracing.UnaryServerInterceptor(grpc_opentracing.WithTracer(tracer)))
 		}
 
-		streamInterceptors = append(streamInterceptors, grpc_opentracing.StreamServerInterceptor(grpc_opentracing.WithTracer(tracer)))
-		interceptors = append(interceptors, grpc_opentracing.UnaryServerInterceptor(grpc_opentracing.WithTracer(tracer)))
+		//baggage校验大小并写入grpc_ctxtags
+		streamInterceptors = append(streamInterceptors, baggageStreamServerInterceptor)
+		interceptors = append(interceptors, baggageUnaryServerInterceptor)
+
+		//span附加消息大小、peer信息及流式消息事件
+		streamInterceptors = append(streamInterceptors, spanTagsStreamServerInterceptor)
+		interceptors = append(interceptors, spanTagsUnaryServerInterceptor)
 	}
 
 	//日志初始化,设置GRPC日志
 	if p.opt.LogFlag {
+		SetServiceName(p.opt.ServiceName)
+		//未设置时保留直接通过SetRotateHook设置的回调
+		if p.opt.LogRotateHook != nil {
+			SetRotateHook(p.opt.LogRotateHook)
+		}
 		logger, err := InitLogger(p.opt.LogFile)
 		if err != nil || logger == nil {
-			grpclog.Error("init logger fail! error<%v>\n", err)
+			grpclog.Errorf("init logger fail! error<%v>\n", err)
 			return
 		}
 
+		//grpc日志使用独立的模块级别，可在log.json的Levels中单独设置
+		logger = Named("grpc")
+		p.logger = logger
+
 		//设置grpc日志
 		grpc_zap.ReplaceGrpcLoggerV2(logger)
-		streamInterceptors = append(streamInterceptors, grpc_zap.StreamServerInterceptor(logger))
-		interceptors = append(interceptors, grpc_zap.UnaryServerInterceptor(logger))
+		streamInterceptors = append(streamInterceptors, grpc_zap.StreamServerInterceptor(logger, grpc_zap.WithMessageProducer(callLogProducer)))
+		interceptors = append(interceptors, grpc_zap.UnaryServerInterceptor(logger, grpc_zap.WithMessageProducer(callLogProducer)))
+
+		//请求与应答内容日志
+		streamInterceptors = append(streamInterceptors, payloadStreamServerInterceptor)
+		interceptors = append(interceptors, payloadUnaryServerInterceptor)
 	}
 
 	if p.opt.PromFlag {
-		streamInterceptors = append(streamInterceptors, grpc_prometheus.StreamServerInterceptor)
-		interceptors = append(interceptors, grpc_prometheus.UnaryServerInterceptor)
+		streamInterceptors = append(streamInterceptors, p.metrics.serverMetrics.StreamServerInterceptor())
+		interceptors = append(interceptors, p.metrics.serverMetrics.UnaryServerInterceptor())
+
+		//延迟直方图附加trace_id exemplar，替代grpc_prometheus的直方图
+		streamInterceptors = append(streamInterceptors, handlingStreamServerInterceptor(p.metrics.serverHandlingSeconds))
+		interceptors = append(interceptors, handlingUnaryServerInterceptor(p.metrics.serverHandlingSeconds))
 	}
 
-	streamInterceptors = append(streamInterceptors, grpc_recovery.StreamServerInterceptor(grpc_recovery.WithRecoveryHandler(panicHandler)))
-	interceptors = append(interceptors, grpc_recovery.UnaryServerInterceptor(grpc_recovery.WithRecoveryHandler(panicHandler)))
+	streamInterceptors = append(streamInterceptors, grpc_recovery.StreamServerInterceptor(grpc_recovery.WithRecoveryHandlerContext(p.recoveryHandler)))
+	interceptors = append(interceptors, grpc_recovery.UnaryServerInterceptor(grpc_recovery.WithRecoveryHandlerContext(p.recoveryHandler)))
+
+	//自定义拦截器
+	streamInterceptors = append(streamInterceptors, p.opt.StreamInterceptors...)
+	interceptors = append(interceptors, p.opt.UnaryInterceptors...)
 
 	//服务注册与注销
 	//if p.opt.RegFlag {
@@ -101,53 +169,238 @@ func (p *GrpcServeWrapper) Init(serviceName string, serviceAddr string) {
 	//}
 
 	//grpc拦截器设置
-	p.svr = grpc.NewServer(
+	serverOpts = append(serverOpts,
 		grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(streamInterceptors...)),
 		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(interceptors...)),
 		grpc.MaxRecvMsgSize(math.MaxInt32))
+	p.svr = grpc.NewServer(serverOpts...)
 
+	//grpc-web包装，供浏览器直接调用
+	if p.opt.GrpcWebFlag {
+		p.web = newGrpcWebServer(p.svr, p.opt)
+	}
 }
 
 func (p *GrpcServeWrapper) GetServer() *grpc.Server {
 	return p.svr
 }
 
+//Metrics 服务独立的prometheus注册表，Init之后可用
+func (p *GrpcServeWrapper) Metrics() *ServiceMetrics {
+	return p.metrics
+}
+
 func (p *GrpcServeWrapper) UnInit() {
 
 }
 
-func (p *GrpcServeWrapper) Run() {
-	startMetrics(p.svr, p.opt.PromAddr)
-	listen, err := net.Listen("tcp", p.opt.ServiceAddr)
+//Run 启动服务并阻塞直到服务退出
+func (p *GrpcServeWrapper) Run() error {
+	if err := p.Start(); err != nil {
+		return err
+	}
+	return p.Wait()
+}
+
+//Start 绑定监听后立即返回，不阻塞
+//优先使用systemd socket activation(LISTEN_FDS)传入的监听，否则监听ServiceAddr
+func (p *GrpcServeWrapper) Start() error {
+	if p.svr == nil {
+		return errNotInit
+	}
+
+	listeners, err := systemdListeners()
 	if err != nil {
-		grpclog.Errorf("grpc listend failed! service-addr:%v, error:<%v>", p.opt.ServiceAddr, err)
-		panic(err.Error())
+		grpclog.Errorf("grpc systemd listeners failed! error:<%v>", err)
+		return err
+	}
+
+	if len(listeners) == 0 {
+		//支持同时监听多个地址，如tcp与unix socket
+		for _, addr := range splitAddrs(p.opt.ServiceAddr) {
+			listen, err := newListener(addr, p.opt.SocketPerm)
+			if err != nil {
+				grpclog.Errorf("grpc listend failed! service-addr:%v, error:<%v>", addr, err)
+				for _, l := range listeners {
+					l.Close()
+				}
+				return err
+			}
+			listeners = append(listeners, listen)
+		}
+	}
+
+	return p.start(listeners...)
+}
+
+//Serve 使用调用方提供的监听启动服务，阻塞直到服务退出
+func (p *GrpcServeWrapper) Serve(listen net.Listener) error {
+	if err := p.start(listen); err != nil {
+		return err
+	}
+	return p.Wait()
+}
+
+//Wait 等待所有监听上的服务退出，返回第一个错误
+func (p *GrpcServeWrapper) Wait() error {
+	p.wg.Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+//Addrs 实际绑定的监听地址，ServiceAddr为:0时可获取系统分配的端口
+func (p *GrpcServeWrapper) Addrs() []net.Addr {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	addrs := make([]net.Addr, 0, len(p.listeners))
+	for _, listen := range p.listeners {
+		addrs = append(addrs, listen.Addr())
+	}
+	return addrs
+}
+
+//Stop 优雅停止服务
+func (p *GrpcServeWrapper) Stop() {
+	p.mu.Lock()
+	httpSvrs := p.httpSvrs
+	p.mu.Unlock()
+
+	//http服务(grpc-web、性能监控)等待处理中的请求完成，超时后强制关闭
+	ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	for _, hs := range httpSvrs {
+		if err := hs.Shutdown(ctx); err != nil {
+			grpclog.Warningf("shutdown http server fail! error<%v>\n", err)
+			hs.Close()
+		}
+	}
+	cancel()
+	if p.svr != nil {
+		p.svr.GracefulStop()
+	}
+
+	//上报剩余的span
+	p.mu.Lock()
+	release := p.releaseTracer
+	p.releaseTracer = nil
+	p.mu.Unlock()
+	if release != nil {
+		release()
 	}
-	grpclog.Infof("grpc-service: %v listen: %v", p.opt.ServiceName, p.opt.ServiceAddr)
-	reflection.Register(p.svr)
-	err = p.svr.Serve(listen)
+
+	//异步日志需在退出前写入文件，并关闭日志文件
+	if p.logger != nil {
+		p.logger.Sync()
+		Close()
+	}
+}
+
+func (p *GrpcServeWrapper) start(listeners ...net.Listener) error {
+	if p.svr == nil {
+		return errNotInit
+	}
+
+	var err error
+	p.once.Do(func() {
+		reflection.Register(p.svr)
+		err = p.startAdmin()
+	})
 	if err != nil {
-		grpclog.Errorf("grpc startup failed!  service-addr:%v, error:<%v>", p.opt.ServiceAddr, err)
-		return
+		for _, listen := range listeners {
+			listen.Close()
+		}
+		return err
+	}
+
+	p.mu.Lock()
+	p.listeners = append(p.listeners, listeners...)
+	p.mu.Unlock()
+
+	for _, listen := range listeners {
+		grpclog.Infof("grpc-service: %v listen: %v", p.opt.ServiceName, listen.Addr())
+		p.wg.Add(1)
+		go func(listen net.Listener) {
+			defer p.wg.Done()
+			if err := p.serve(listen); err != nil {
+				grpclog.Errorf("grpc startup failed!  service-addr:%v, error:<%v>", listen.Addr(), err)
+				p.mu.Lock()
+				if p.err == nil {
+					p.err = err
+				}
+				p.mu.Unlock()
+			}
+		}(listen)
 	}
+	return nil
 }
 
-var panicHandler = grpc_recovery.RecoveryHandlerFunc(func(p interface{}) error {
-	buf := make([]byte, 1<<16)
-	runtime.Stack(buf, true)
-	grpclog.Errorf("grpc panice recovered", zap.String("panic_recovered", string(buf)))
-	return status.Errorf(codes.Internal, "%s", p)
-})
+func (p *GrpcServeWrapper) serve(listen net.Listener) error {
+	if p.web == nil || p.opt.grpcWebOnAdmin() {
+		return p.svr.Serve(listen)
+	}
+
+	grpclog.Infof("grpc-web listen: %v", listen.Addr())
+	hs := newGrpcWebHTTPServer(p.svr, p.web)
+	p.mu.Lock()
+	p.httpSvrs = append(p.httpSvrs, hs)
+	p.mu.Unlock()
+	if err := hs.Serve(listen); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+//startAdmin 启动性能监控端口，grpc-web为admin模式时一并挂载
+func (p *GrpcServeWrapper) startAdmin() error {
+	webOnAdmin := p.web != nil && p.opt.grpcWebOnAdmin()
+	if !p.opt.PromFlag && !webOnAdmin {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	if p.opt.PromFlag {
+		startMetrics(p.svr, p.metrics, mux)
+	}
+
+	//内存日志查询，只在日志配置中开启Ring时挂载
+	if p.opt.LogFlag && RingEnabled() {
+		mux.Handle("/debug/logs", RingHandler())
+		mux.Handle("/debug/logs/tail", RingTailHandler())
+	}
+
+	//其余请求(如pprof)交给默认mux处理
+	if webOnAdmin {
+		mux.Handle("/", grpcWebHandler(p.web, http.DefaultServeMux))
+		grpclog.Infof("grpc-web listen: %v", p.opt.PromAddr)
+	} else {
+		mux.Handle("/", http.DefaultServeMux)
+	}
+
+	listen, err := net.Listen("tcp", p.opt.PromAddr)
+	if err != nil {
+		grpclog.Errorf("prometheus listen failed! bind-addr:%v, error:<%v>", p.opt.PromAddr, err)
+		return err
+	}
+
+	hs := &http.Server{Handler: mux}
+	p.mu.Lock()
+	p.httpSvrs = append(p.httpSvrs, hs)
+	p.mu.Unlock()
 
-func startMetrics(grpcServer *grpc.Server, promAddr string) {
-	grpc_prometheus.EnableHandlingTimeHistogram()
-	grpc_prometheus.Register(grpcServer)
-	http.Handle("/metrics", promhttp.Handler())
 	go func() {
-		grpclog.Infof("prometheus listen: %v/metris", promAddr)
-		if err := http.ListenAndServe(promAddr, nil); err != nil {
-			grpclog.Errorf("prometheus listen failed! bind-addr:%v, error:<%v>", promAddr, err)
-			panic(err)
+		grpclog.Infof("prometheus listen: %v/metrics", listen.Addr())
+		if err := hs.Serve(listen); err != nil && err != http.ErrServerClosed {
+			grpclog.Errorf("prometheus serve failed! bind-addr:%v, error:<%v>", listen.Addr(), err)
 		}
 	}()
+	return nil
+}
+
+//startMetrics 输出服务注册表的指标，请求头Accept为application/openmetrics-text时以OpenMetrics格式输出，exemplar只在该格式中输出
+func startMetrics(grpcServer *grpc.Server, metrics *ServiceMetrics, mux *http.ServeMux) {
+	metrics.serverMetrics.InitializeMetrics(grpcServer)
+	handler := promhttp.HandlerFor(metrics.Gatherer(), promhttp.HandlerOpts{EnableOpenMetrics: true})
+	mux.Handle("/metrics", promhttp.InstrumentMetricHandler(metrics.Registerer(), handler))
 }
@@ -0,0 +1,209 @@
+package grpc
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/happyhakka/grpc-wrapper/trc"
+
+	"github.com/golang/protobuf/proto"
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	grpc_retry "github.com/grpc-ecosystem/go-grpc-middleware/retry"
+	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
+	"github.com/grpc-ecosystem/go-grpc-middleware/util/metautils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/stats"
+)
+
+const (
+	TAG_REQUEST_SIZE  = "grpc.request.size"  //请求消息大小(字节)
+	TAG_RESPONSE_SIZE = "grpc.response.size" //应答消息大小(字节)
+	TAG_RETRY_ATTEMPT = "grpc.retry.attempt" //客户端重试次数，首次调用为0
+	TAG_POOL_TARGET   = "pool.target"        //连接池选择的服务地址
+	TAG_POOL_WAIT     = "pool.wait_ms"       //从连接池获取连接的耗时(毫秒)
+
+	EVENT_MESSAGE = "message" //流式调用每条消息的事件
+)
+
+//messageSize protobuf消息序列化后的大小
+func messageSize(msg interface{}) int {
+	if pm, ok := msg.(proto.Message); ok && pm != nil {
+		return proto.Size(pm)
+	}
+	return 0
+}
+
+//messageEvent 记录流式调用收发的每条消息
+func messageEvent(ctx context.Context, typ string, id int32, size int) {
+	trc.AddEvent(ctx, EVENT_MESSAGE, "message.type", typ, "message.id", id, "message.size", size)
+}
+
+//spanTagsUnaryServerInterceptor 在服务端span上记录消息大小及grpc_ctxtags中的字段(含peer.address)，
+//需位于调用链拦截器之后
+func spanTagsUnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	trc.SetTag(ctx, TAG_REQUEST_SIZE, messageSize(req))
+	resp, err := handler(ctx, req)
+	if err == nil {
+		trc.SetTag(ctx, TAG_RESPONSE_SIZE, messageSize(resp))
+	}
+
+	//handler中添加的ctxtags字段一并记录
+	trc.SetTags(ctx, grpc_ctxtags.Extract(ctx).Values())
+	return resp, err
+}
+
+//spanTagsStreamServerInterceptor 流式调用在服务端span上记录每条消息的事件
+func spanTagsStreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	wrapped := &spanTagsServerStream{WrappedServerStream: grpc_middleware.WrapServerStream(ss)}
+	err := handler(srv, wrapped)
+	trc.SetTags(ss.Context(), grpc_ctxtags.Extract(ss.Context()).Values())
+	return err
+}
+
+type spanTagsServerStream struct {
+	*grpc_middleware.WrappedServerStream
+	sent int32
+	recv int32
+}
+
+func (s *spanTagsServerStream) RecvMsg(m interface{}) error {
+	err := s.WrappedServerStream.RecvMsg(m)
+	if err == nil {
+		messageEvent(s.Context(), "RECEIVED", atomic.AddInt32(&s.recv, 1), messageSize(m))
+	}
+	return err
+}
+
+func (s *spanTagsServerStream) SendMsg(m interface{}) error {
+	err := s.WrappedServerStream.SendMsg(m)
+	if err == nil {
+		messageEvent(s.Context(), "SENT", atomic.AddInt32(&s.sent, 1), messageSize(m))
+	}
+	return err
+}
+
+type poolWaitKey struct{}
+
+//withPoolWait 记录从连接池获取连接的耗时，供客户端span使用
+func withPoolWait(ctx context.Context, wait time.Duration) context.Context {
+	return context.WithValue(ctx, poolWaitKey{}, wait)
+}
+
+//clientCallTags 连接池选择的服务地址、重试次数及获取连接的耗时
+func clientCallTags(ctx context.Context, cc *grpc.ClientConn) map[string]interface{} {
+	attempt, _ := strconv.Atoi(metautils.ExtractOutgoing(ctx).Get(grpc_retry.AttemptMetadataKey))
+	tags := map[string]interface{}{
+		TAG_POOL_TARGET:   cc.Target(),
+		TAG_RETRY_ATTEMPT: attempt,
+	}
+	if wait, ok := ctx.Value(poolWaitKey{}).(time.Duration); ok {
+		tags[TAG_POOL_WAIT] = float64(wait) / float64(time.Millisecond)
+	}
+	return tags
+}
+
+//spanTagsUnaryClientInterceptor opentracing客户端span附加信息，需位于调用链拦截器之后
+func spanTagsUnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	trc.SetTags(ctx, clientCallTags(ctx, cc))
+	trc.SetTag(ctx, TAG_REQUEST_SIZE, messageSize(req))
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	if err == nil {
+		trc.SetTag(ctx, TAG_RESPONSE_SIZE, messageSize(reply))
+	}
+	return err
+}
+
+//spanTagsStreamClientInterceptor opentracing流式调用在客户端span上记录每条消息的事件
+func spanTagsStreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	trc.SetTags(ctx, clientCallTags(ctx, cc))
+	cs, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &spanTagsClientStream{ClientStream: cs, ctx: ctx}, nil
+}
+
+type spanTagsClientStream struct {
+	grpc.ClientStream
+	ctx  context.Context
+	sent int32
+	recv int32
+}
+
+func (s *spanTagsClientStream) SendMsg(m interface{}) error {
+	err := s.ClientStream.SendMsg(m)
+	if err == nil {
+		messageEvent(s.ctx, "SENT", atomic.AddInt32(&s.sent, 1), messageSize(m))
+	}
+	return err
+}
+
+func (s *spanTagsClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err == nil {
+		messageEvent(s.ctx, "RECEIVED", atomic.AddInt32(&s.recv, 1), messageSize(m))
+	}
+	return err
+}
+
+type clientCallTagsKey struct{}
+
+//otelCallTagsUnaryClientInterceptor OpenTelemetry客户端span在stats handler中创建，
+//拦截器中只能将附加信息放入ctx，由spanTagsStatsHandler写入span
+func otelCallTagsUnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	ctx = context.WithValue(ctx, clientCallTagsKey{}, clientCallTags(ctx, cc))
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+func otelCallTagsStreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	ctx = context.WithValue(ctx, clientCallTagsKey{}, clientCallTags(ctx, cc))
+	return streamer(ctx, desc, cc, method, opts...)
+}
+
+//spanTagsStatsHandler 包装otelgrpc客户端stats handler，记录连接池信息、消息大小及流式调用每条消息的事件，
+//并回填带有客户端span的ctx用于延迟直方图的exemplar
+type spanTagsStatsHandler struct {
+	stats.Handler
+}
+
+type rpcMessages struct {
+	stream bool
+	sent   int32
+	recv   int32
+}
+
+type rpcMessagesKey struct{}
+
+func (h spanTagsStatsHandler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	ctx = h.Handler.TagRPC(ctx, info)
+	setRPCTrace(ctx)
+	if tags, ok := ctx.Value(clientCallTagsKey{}).(map[string]interface{}); ok {
+		trc.SetTags(ctx, tags)
+	}
+	return context.WithValue(ctx, rpcMessagesKey{}, &rpcMessages{})
+}
+
+func (h spanTagsStatsHandler) HandleRPC(ctx context.Context, rs stats.RPCStats) {
+	//span在End时结束，需先于otelgrpc处理
+	if msgs, ok := ctx.Value(rpcMessagesKey{}).(*rpcMessages); ok {
+		switch st := rs.(type) {
+		case *stats.Begin:
+			msgs.stream = st.IsClientStream || st.IsServerStream
+		case *stats.OutPayload:
+			if msgs.stream {
+				messageEvent(ctx, "SENT", atomic.AddInt32(&msgs.sent, 1), st.Length)
+			} else {
+				trc.SetTag(ctx, TAG_REQUEST_SIZE, st.Length)
+			}
+		case *stats.InPayload:
+			if msgs.stream {
+				messageEvent(ctx, "RECEIVED", atomic.AddInt32(&msgs.recv, 1), st.Length)
+			} else {
+				trc.SetTag(ctx, TAG_RESPONSE_SIZE, st.Length)
+			}
+		}
+	}
+	h.Handler.HandleRPC(ctx, rs)
+}
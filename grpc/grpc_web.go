@@ -0,0 +1,61 @@
+package grpc
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/improbable-eng/grpc-web/go/grpcweb"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+)
+
+//newGrpcWebServer 将grpc服务包装为grpc-web服务，同时支持binary与text两种模式
+func newGrpcWebServer(svr *grpc.Server, o *GrpcSysOption) *grpcweb.WrappedGrpcServer {
+	opts := []grpcweb.Option{
+		grpcweb.WithOriginFunc(allowedOriginFunc(o.GrpcWebOrigins)),
+		grpcweb.WithCorsForRegisteredEndpointsOnly(true),
+	}
+	if len(o.GrpcWebHeaders) > 0 {
+		opts = append(opts, grpcweb.WithAllowedRequestHeaders(o.GrpcWebHeaders))
+	}
+	return grpcweb.WrapServer(svr, opts...)
+}
+
+//allowedOriginFunc 跨域来源校验，未配置时只允许同源请求，配置了*时允许所有来源
+func allowedOriginFunc(origins []string) func(string) bool {
+	allowed := make(map[string]bool)
+	for _, origin := range origins {
+		origin = strings.ToLower(strings.TrimSpace(origin))
+		if origin != "" {
+			allowed[origin] = true
+		}
+	}
+
+	return func(origin string) bool {
+		return allowed["*"] || allowed[strings.ToLower(origin)]
+	}
+}
+
+//grpcWebHandler 处理grpc-web请求及其cors预检请求，其余请求交给next处理
+func grpcWebHandler(web *grpcweb.WrappedGrpcServer, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if web.IsGrpcWebRequest(r) || web.IsAcceptableGrpcCorsRequest(r) {
+			web.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//newGrpcWebHTTPServer 在同一端口上同时提供grpc(h2c)与grpc-web服务
+func newGrpcWebHTTPServer(svr *grpc.Server, web *grpcweb.WrappedGrpcServer) *http.Server {
+	return &http.Server{
+		Handler: h2c.NewHandler(grpcWebHandler(web, svr), &http2.Server{}),
+	}
+}
+
+//grpcWebOnAdmin grpc-web是否挂载在性能监控端口上
+func (p *GrpcSysOption) grpcWebOnAdmin() bool {
+	return strings.ToLower(p.GrpcWebMode) == GRPC_WEB_MODE_ADMIN
+}
@@ -0,0 +1,163 @@
+package grpctest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//Faults 服务端故障注入：延迟、错误、流中断
+type Faults struct {
+	mu         sync.Mutex
+	latency    time.Duration
+	failTimes  int
+	failErr    error
+	dropAfter  int
+	methods    map[string]bool
+	dropStatus error
+}
+
+//NewFaults 创建空的故障注入配置
+func NewFaults() *Faults {
+	f := &Faults{}
+	f.Reset()
+	return f
+}
+
+//Reset 清除所有故障
+func (f *Faults) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.latency = 0
+	f.failTimes = 0
+	f.failErr = nil
+	f.dropAfter = -1
+	f.methods = nil
+	f.dropStatus = status.Error(codes.Unavailable, "grpctest: stream dropped")
+}
+
+//SetLatency 每次调用增加的延迟
+func (f *Faults) SetLatency(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.latency = d
+}
+
+//FailNext 接下来的n次调用直接返回err，err为空时返回Unavailable
+func (f *Faults) FailNext(n int, err error) {
+	if err == nil {
+		err = status.Error(codes.Unavailable, "grpctest: injected fault")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failTimes = n
+	f.failErr = err
+}
+
+//DropStreamsAfter 服务端流发送n条消息后中断，n<0时关闭
+func (f *Faults) DropStreamsAfter(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.dropAfter = n
+}
+
+//OnlyMethods 故障仅作用于指定方法，如/order.OrderService/GetOrderInfo，为空时作用于所有方法
+func (f *Faults) OnlyMethods(methods ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.methods = nil
+	if len(methods) > 0 {
+		f.methods = make(map[string]bool)
+		for _, m := range methods {
+			f.methods[m] = true
+		}
+	}
+}
+
+//inject 执行延迟与错误注入，返回需注入的错误
+func (f *Faults) inject(ctx context.Context, method string) error {
+	f.mu.Lock()
+	if f.methods != nil && !f.methods[method] {
+		f.mu.Unlock()
+		return nil
+	}
+	latency := f.latency
+	var err error
+	if f.failTimes > 0 {
+		f.failTimes--
+		err = f.failErr
+	}
+	f.mu.Unlock()
+
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-timer.C:
+		}
+	}
+	return err
+}
+
+//UnaryServerInterceptor unary故障注入拦截器
+func (f *Faults) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := f.inject(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+//StreamServerInterceptor stream故障注入拦截器
+func (f *Faults) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := f.inject(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+
+		f.mu.Lock()
+		dropAfter := f.dropAfter
+		dropStatus := f.dropStatus
+		f.mu.Unlock()
+
+		if dropAfter < 0 {
+			return handler(srv, ss)
+		}
+
+		ds := &dropStream{ServerStream: ss, left: dropAfter, err: dropStatus}
+		if err := handler(srv, ds); err != nil {
+			return err
+		}
+		if ds.dropped {
+			return dropStatus
+		}
+		return nil
+	}
+}
+
+//dropStream 发送指定数量消息后中断的流
+type dropStream struct {
+	grpc.ServerStream
+	left    int
+	err     error
+	dropped bool
+}
+
+func (s *dropStream) SendMsg(m interface{}) error {
+	if s.left <= 0 {
+		s.dropped = true
+		return s.err
+	}
+	s.left--
+	return s.ServerStream.SendMsg(m)
+}
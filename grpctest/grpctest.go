@@ -0,0 +1,131 @@
+package grpctest
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	wrapper "github.com/happyhakka/grpc-wrapper/grpc"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const (
+	bufTarget = "bufnet"
+	bufSize   = 1 << 20
+)
+
+//Option 测试服务配置
+type Option struct {
+	ServiceName string                 //服务名称
+	BufSize     int                    //bufconn缓冲区大小
+	SysOption   *wrapper.GrpcSysOption //服务端配置，为空时使用默认配置
+	PoolOption  *wrapper.PoolOption    //连接池配置，为空时使用默认配置，InitTargets会被替换为bufconn
+	DialOptions []grpc.DialOption      //连接池额外的连接选项
+}
+
+//NewOption 默认测试配置，性能监控端口使用随机端口避免冲突
+func NewOption(serviceName string) *Option {
+	o := &Option{}
+	o.ServiceName = serviceName
+	o.BufSize = bufSize
+
+	o.SysOption = wrapper.NewGrpcSysOption()
+	o.SysOption.PromAddr = "127.0.0.1:0"
+
+	o.PoolOption = wrapper.NewPoolOption(serviceName, []string{bufTarget}, 1, 10)
+	return o
+}
+
+//Server 基于bufconn的进程内测试服务，包含GrpcServeWrapper全部拦截器
+type Server struct {
+	Wrapper  *wrapper.GrpcServeWrapper
+	Pool     *wrapper.GrpcPool
+	Listener *bufconn.Listener
+	Faults   *Faults
+}
+
+//NewServer 启动测试服务并创建连接到该服务的连接池，测试结束时通过t.Cleanup自动释放
+//register 用于注册业务服务，如 func(s *grpc.Server) { order.RegisterOrderServiceServer(s, impl) }
+func NewServer(t testing.TB, o *Option, register func(*grpc.Server)) *Server {
+	t.Helper()
+
+	if o == nil {
+		o = NewOption("grpctest")
+	}
+	if o.SysOption == nil {
+		o.SysOption = wrapper.NewGrpcSysOption()
+		o.SysOption.PromAddr = "127.0.0.1:0"
+	}
+	if o.PoolOption == nil {
+		o.PoolOption = wrapper.NewPoolOption(o.ServiceName, nil, 1, 10)
+	}
+	if o.BufSize <= 0 {
+		o.BufSize = bufSize
+	}
+
+	s := &Server{}
+	s.Faults = NewFaults()
+	s.Listener = bufconn.Listen(o.BufSize)
+
+	//故障注入拦截器位于内置拦截器之后，注入的错误同样会被日志与监控记录
+	//在配置副本上追加，调用方的Option不变，重复使用时不会重复添加
+	sysOption := *o.SysOption
+	sysOption.UnaryInterceptors = make([]grpc.UnaryServerInterceptor, 0, len(o.SysOption.UnaryInterceptors)+1)
+	sysOption.UnaryInterceptors = append(sysOption.UnaryInterceptors, o.SysOption.UnaryInterceptors...)
+	sysOption.UnaryInterceptors = append(sysOption.UnaryInterceptors, s.Faults.UnaryServerInterceptor())
+	sysOption.StreamInterceptors = make([]grpc.StreamServerInterceptor, 0, len(o.SysOption.StreamInterceptors)+1)
+	sysOption.StreamInterceptors = append(sysOption.StreamInterceptors, o.SysOption.StreamInterceptors...)
+	sysOption.StreamInterceptors = append(sysOption.StreamInterceptors, s.Faults.StreamServerInterceptor())
+
+	s.Wrapper = wrapper.NewGrpcServeWrapper()
+	s.Wrapper.SetOption(&sysOption)
+	s.Wrapper.Init(o.ServiceName, ":0")
+	if s.Wrapper.GetServer() == nil {
+		t.Fatalf("grpctest: init grpc server failed")
+	}
+	if register != nil {
+		register(s.Wrapper.GetServer())
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.Wrapper.Serve(s.Listener)
+	}()
+	t.Cleanup(func() {
+		s.Wrapper.Stop()
+		if err := <-errc; err != nil {
+			t.Logf("grpctest: serve exit: %v", err)
+		}
+	})
+
+	o.PoolOption.InitTargets = []string{bufTarget}
+	dialOptions := append([]grpc.DialOption{grpc.WithContextDialer(s.dial)}, o.DialOptions...)
+	pool, err := wrapper.NewDefaultGrpcPool(o.PoolOption, dialOptions...)
+	if err != nil {
+		t.Fatalf("grpctest: init grpc pool failed: %v", err)
+	}
+	s.Pool = pool
+	t.Cleanup(pool.Close)
+
+	return s
+}
+
+//Conn 从连接池获取连接，测试结束时自动放回
+func (s *Server) Conn(t testing.TB) *grpc.ClientConn {
+	t.Helper()
+
+	conn, err := s.Pool.Get()
+	if err != nil {
+		t.Fatalf("grpctest: get conn failed: %v", err)
+	}
+	t.Cleanup(func() {
+		s.Pool.Put(conn)
+	})
+	return conn
+}
+
+func (s *Server) dial(ctx context.Context, addr string) (net.Conn, error) {
+	return s.Listener.Dial()
+}
@@ -0,0 +1,440 @@
+package grpctest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	wrapper "github.com/happyhakka/grpc-wrapper/grpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const (
+	methodEcho  = "/grpctest.Test/Echo"
+	methodPanic = "/grpctest.Test/Panic"
+	methodList  = "/grpctest.Test/List"
+
+	listCount = 5
+)
+
+//testServer 测试服务，Echo原样返回，Panic直接panic，List返回listCount条消息
+type testServer struct {
+	calls int32
+}
+
+var testServiceDesc = grpc.ServiceDesc{
+	ServiceName: "grpctest.Test",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Echo", Handler: echoHandler},
+		{MethodName: "Panic", Handler: panicHandler},
+	},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "List", Handler: listHandler, ServerStreams: true},
+	},
+}
+
+var listStreamDesc = &grpc.StreamDesc{StreamName: "List", ServerStreams: true}
+
+func unaryHandler(method string, fn func(ctx context.Context, in *wrapperspb.StringValue) (*wrapperspb.StringValue, error)) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		in := &wrapperspb.StringValue{}
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			atomic.AddInt32(&srv.(*testServer).calls, 1)
+			return fn(ctx, req.(*wrapperspb.StringValue))
+		}
+		if interceptor == nil {
+			return handler(ctx, in)
+		}
+		return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: method}, handler)
+	}
+}
+
+var (
+	echoHandler = unaryHandler(methodEcho, func(ctx context.Context, in *wrapperspb.StringValue) (*wrapperspb.StringValue, error) {
+		return in, nil
+	})
+	panicHandler = unaryHandler(methodPanic, func(ctx context.Context, in *wrapperspb.StringValue) (*wrapperspb.StringValue, error) {
+		panic("secret: " + in.GetValue())
+	})
+)
+
+func listHandler(srv interface{}, ss grpc.ServerStream) error {
+	in := &wrapperspb.StringValue{}
+	if err := ss.RecvMsg(in); err != nil {
+		return err
+	}
+	for i := 0; i < listCount; i++ {
+		if err := ss.SendMsg(wrapperspb.String(fmt.Sprintf("%s-%d", in.GetValue(), i))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//newTestOption 关闭日志，避免在包目录下生成日志文件
+func newTestOption() *Option {
+	o := NewOption("grpctest")
+	o.SysOption.LogFlag = false
+	return o
+}
+
+func newTestServer(t *testing.T, o *Option) (*Server, *testServer) {
+	t.Helper()
+	impl := &testServer{}
+	s := NewServer(t, o, func(s *grpc.Server) {
+		s.RegisterService(&testServiceDesc, impl)
+	})
+	return s, impl
+}
+
+func echo(ctx context.Context, conn *grpc.ClientConn, value string) (string, error) {
+	out := &wrapperspb.StringValue{}
+	err := conn.Invoke(ctx, methodEcho, wrapperspb.String(value), out)
+	return out.GetValue(), err
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestPoolGetPut(t *testing.T) {
+	s, _ := newTestServer(t, newTestOption())
+	ctx := testContext(t)
+
+	first, err := s.Pool.Get()
+	if err != nil {
+		t.Fatalf("get conn: %v", err)
+	}
+	if got, err := echo(ctx, first, "hello"); err != nil || got != "hello" {
+		t.Fatalf("echo = %q, %v, want hello", got, err)
+	}
+
+	//空闲连接已取出，再次获取时新建连接
+	second, err := s.Pool.Get()
+	if err != nil {
+		t.Fatalf("get second conn: %v", err)
+	}
+	if second == first {
+		t.Fatalf("expect new conn when pool is empty")
+	}
+	if got, err := echo(ctx, second, "world"); err != nil || got != "world" {
+		t.Fatalf("echo = %q, %v, want world", got, err)
+	}
+
+	//放回后再次获取复用同一连接
+	if err := s.Pool.Put(first); err != nil {
+		t.Fatalf("put conn: %v", err)
+	}
+	again, err := s.Pool.Get()
+	if err != nil {
+		t.Fatalf("get conn again: %v", err)
+	}
+	if again != first {
+		t.Fatalf("expect idle conn to be reused")
+	}
+	s.Pool.Put(again)
+	s.Pool.Put(second)
+}
+
+func TestOptionReuse(t *testing.T) {
+	o := newTestOption()
+	newTestServer(t, o)
+	newTestServer(t, o)
+
+	if n := len(o.SysOption.UnaryInterceptors); n != 0 {
+		t.Fatalf("unary interceptors of caller option = %d, want 0", n)
+	}
+	if n := len(o.SysOption.StreamInterceptors); n != 0 {
+		t.Fatalf("stream interceptors of caller option = %d, want 0", n)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	o := newTestOption()
+	o.PoolOption.ClientRetryFlag = true
+	o.PoolOption.ClientRetryTimes = 3
+	o.PoolOption.ClientRetryTimeout = 1
+	s, impl := newTestServer(t, o)
+	conn := s.Conn(t)
+	ctx := testContext(t)
+
+	//Unavailable可重试，故障在首次调用时消耗，第二次调用成功
+	s.Faults.FailNext(1, nil)
+	if got, err := echo(ctx, conn, "retry"); err != nil || got != "retry" {
+		t.Fatalf("echo with retry = %q, %v, want retry", got, err)
+	}
+	if calls := atomic.LoadInt32(&impl.calls); calls != 1 {
+		t.Fatalf("handler calls = %d, want 1", calls)
+	}
+
+	//InvalidArgument不重试，直接返回给调用方
+	s.Faults.FailNext(1, status.Error(codes.InvalidArgument, "bad request"))
+	if _, err := echo(ctx, conn, "fail"); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("echo error = %v, want InvalidArgument", err)
+	}
+	if calls := atomic.LoadInt32(&impl.calls); calls != 1 {
+		t.Fatalf("handler calls = %d, want 1", calls)
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	t.Run("sanitized", func(t *testing.T) {
+		s, _ := newTestServer(t, newTestOption())
+		conn := s.Conn(t)
+
+		err := conn.Invoke(testContext(t), methodPanic, wrapperspb.String("password"), &wrapperspb.StringValue{})
+		if status.Code(err) != codes.Internal {
+			t.Fatalf("panic error = %v, want Internal", err)
+		}
+		if msg := status.Convert(err).Message(); strings.Contains(msg, "secret") {
+			t.Fatalf("panic detail leaked to client: %q", msg)
+		}
+
+		//panic后服务继续可用
+		if got, err := echo(testContext(t), conn, "alive"); err != nil || got != "alive" {
+			t.Fatalf("echo after panic = %q, %v", got, err)
+		}
+	})
+
+	t.Run("hook", func(t *testing.T) {
+		o := newTestOption()
+		var recovered atomic.Value
+		o.SysOption.RecoveryHook = func(ctx context.Context, p interface{}, stack []byte) error {
+			recovered.Store(fmt.Sprint(p))
+			return status.Error(codes.Unavailable, "try later")
+		}
+		s, _ := newTestServer(t, o)
+
+		err := s.Conn(t).Invoke(testContext(t), methodPanic, wrapperspb.String("hook"), &wrapperspb.StringValue{})
+		if status.Code(err) != codes.Unavailable {
+			t.Fatalf("panic error = %v, want hook error Unavailable", err)
+		}
+		if got, _ := recovered.Load().(string); got != "secret: hook" {
+			t.Fatalf("hook panic value = %q", got)
+		}
+	})
+
+	t.Run("detail", func(t *testing.T) {
+		o := newTestOption()
+		o.SysOption.PanicDetailFlag = true
+		s, _ := newTestServer(t, o)
+
+		err := s.Conn(t).Invoke(testContext(t), methodPanic, wrapperspb.String("detail"), &wrapperspb.StringValue{})
+		if msg := status.Convert(err).Message(); status.Code(err) != codes.Internal || msg != "secret: detail" {
+			t.Fatalf("panic error = %v, want Internal with detail", err)
+		}
+	})
+}
+
+func TestDropStreamsAfter(t *testing.T) {
+	s, _ := newTestServer(t, newTestOption())
+	conn := s.Conn(t)
+	ctx := testContext(t)
+
+	list := func() (int, error) {
+		cs, err := conn.NewStream(ctx, listStreamDesc, methodList)
+		if err != nil {
+			return 0, err
+		}
+		if err := cs.SendMsg(wrapperspb.String("item")); err != nil {
+			return 0, err
+		}
+		if err := cs.CloseSend(); err != nil {
+			return 0, err
+		}
+		n := 0
+		for {
+			if err := cs.RecvMsg(&wrapperspb.StringValue{}); err != nil {
+				if err == io.EOF {
+					return n, nil
+				}
+				return n, err
+			}
+			n++
+		}
+	}
+
+	s.Faults.DropStreamsAfter(2)
+	n, err := list()
+	if status.Code(err) != codes.Unavailable || n != 2 {
+		t.Fatalf("dropped stream received %d messages, error %v, want 2 and Unavailable", n, err)
+	}
+
+	s.Faults.DropStreamsAfter(-1)
+	if n, err := list(); err != nil || n != listCount {
+		t.Fatalf("stream received %d messages, error %v, want %d", n, err, listCount)
+	}
+}
+
+//metricCount 服务注册表中指标的样本数
+func metricCount(t *testing.T, s *Server, name string) int {
+	t.Helper()
+	mfs, err := s.Wrapper.Metrics().Registry().Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() == name {
+			return len(mf.GetMetric())
+		}
+	}
+	return 0
+}
+
+//counterSum 服务注册表中计数器全部样本之和
+func counterSum(t *testing.T, s *Server, name string) float64 {
+	t.Helper()
+	mfs, err := s.Wrapper.Metrics().Registry().Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+	sum := 0.0
+	for _, mf := range mfs {
+		if mf.GetName() == name {
+			for _, m := range mf.GetMetric() {
+				sum += m.GetCounter().GetValue()
+			}
+		}
+	}
+	return sum
+}
+
+func TestServiceMetricsIsolated(t *testing.T) {
+	newOption := func(name string) *Option {
+		o := newTestOption()
+		o.ServiceName = name
+		return o
+	}
+	first, _ := newTestServer(t, newOption("grpctest-first"))
+	second, _ := newTestServer(t, newOption("grpctest-second"))
+
+	first.Conn(t).Invoke(testContext(t), methodPanic, wrapperspb.String("first"), &wrapperspb.StringValue{})
+	if _, err := echo(testContext(t), first.Conn(t), "first"); err != nil {
+		t.Fatalf("echo: %v", err)
+	}
+
+	if n := metricCount(t, first, "grpc_server_panics_total"); n != 1 {
+		t.Fatalf("first service panics metrics = %d, want 1", n)
+	}
+	if n := metricCount(t, first, "grpc_server_handling_seconds"); n == 0 {
+		t.Fatalf("first service has no handling histogram")
+	}
+	//其他服务的注册表不包含该服务的观测值
+	if n := metricCount(t, second, "grpc_server_panics_total"); n != 0 {
+		t.Fatalf("second service panics metrics = %d, want 0", n)
+	}
+	if n := metricCount(t, second, "grpc_server_handling_seconds"); n != 0 {
+		t.Fatalf("second service handling histogram = %d, want 0", n)
+	}
+	if n := counterSum(t, first, "grpc_server_handled_total"); n != 2 {
+		t.Fatalf("first service handled total = %v, want 2", n)
+	}
+	if n := counterSum(t, second, "grpc_server_handled_total"); n != 0 {
+		t.Fatalf("second service handled total = %v, want 0", n)
+	}
+}
+
+func TestServiceGathererMerge(t *testing.T) {
+	o := newTestOption()
+	o.PoolOption.PromFlag = true
+	s, _ := newTestServer(t, o)
+
+	//同一服务中一个连接池记录到服务注册表，另一个(s.Pool)记录到默认注册表
+	po := wrapper.NewPoolOption(o.ServiceName, []string{bufTarget}, 1, 10)
+	po.PromFlag = true
+	po.Metrics = s.Wrapper.Metrics()
+	pool, err := wrapper.NewDefaultGrpcPool(po, grpc.WithContextDialer(s.dial))
+	if err != nil {
+		t.Fatalf("init grpc pool: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	conn, err := pool.Get()
+	if err != nil {
+		t.Fatalf("get conn: %v", err)
+	}
+	defer pool.Put(conn)
+
+	ctx := testContext(t)
+	if _, err := echo(ctx, conn, "service"); err != nil {
+		t.Fatalf("echo: %v", err)
+	}
+	if _, err := echo(ctx, s.Conn(t), "default"); err != nil {
+		t.Fatalf("echo: %v", err)
+	}
+
+	mfs, err := s.Wrapper.Metrics().Gatherer().Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+	var service, fallback bool
+	for _, mf := range mfs {
+		if mf.GetName() != "grpc_client_handling_seconds" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			labels := make(map[string]string)
+			for _, lp := range m.GetLabel() {
+				labels[lp.GetName()] = lp.GetValue()
+			}
+			if labels["grpc_method"] != "Echo" {
+				continue
+			}
+			if labels[wrapper.METRIC_LABEL_SERVICE] == o.ServiceName {
+				service = true
+			} else if _, ok := labels[wrapper.METRIC_LABEL_SERVICE]; !ok {
+				fallback = true
+			}
+		}
+	}
+	//服务注册表与默认注册表的同名直方图均输出
+	if !service || !fallback {
+		t.Fatalf("client handling histogram service=%v default=%v, want both", service, fallback)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	s, _ := newTestServer(t, newTestOption())
+	conn := s.Conn(t)
+
+	for _, tc := range []struct {
+		name  string
+		id    string
+		reuse bool
+	}{
+		{"valid", "req-0123456789abcdef", true},
+		{"max length", strings.Repeat("a", 128), true},
+		{"too long", strings.Repeat("a", 129), false},
+		{"space", "req 1", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := metadata.AppendToOutgoingContext(testContext(t), wrapper.REQUEST_ID_HEADER, tc.id)
+			var header metadata.MD
+			err := conn.Invoke(ctx, methodEcho, wrapperspb.String("id"), &wrapperspb.StringValue{}, grpc.Header(&header))
+			if err != nil {
+				t.Fatalf("echo: %v", err)
+			}
+			ids := header.Get(wrapper.REQUEST_ID_HEADER)
+			if len(ids) != 1 || ids[0] == "" {
+				t.Fatalf("response request id = %v", ids)
+			}
+			//不合法的上游请求id重新生成
+			if reused := ids[0] == tc.id; reused != tc.reuse {
+				t.Fatalf("response request id = %q, reuse upstream %q = %v, want %v", ids[0], tc.id, reused, tc.reuse)
+			}
+		})
+	}
+}
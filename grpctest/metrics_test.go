@@ -0,0 +1,114 @@
+package grpctest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	wrapper "github.com/happyhakka/grpc-wrapper/grpc"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+//listHandlingCount 默认注册表中List调用的客户端延迟样本数
+func listHandlingCount(t *testing.T) uint64 {
+	t.Helper()
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != "grpc_client_handling_seconds" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			labels := make(map[string]string)
+			for _, lp := range m.GetLabel() {
+				labels[lp.GetName()] = lp.GetValue()
+			}
+			if labels["grpc_service"] == "grpctest.Test" && labels["grpc_method"] == "List" {
+				return m.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+	return 0
+}
+
+//scrapeOpenMetrics 以OpenMetrics格式抓取默认注册表，exemplar只在该格式中输出
+func scrapeOpenMetrics(t *testing.T) string {
+	t.Helper()
+	handler := promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true})
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Body.String()
+}
+
+func TestClientStreamHandling(t *testing.T) {
+	o := newTestOption()
+	o.PoolOption.PromFlag = true
+	recorder := o.RecordSpans(wrapper.TRACER_TYPE_OPENTRACING)
+	s, _ := newTestServer(t, o)
+	conn := s.Conn(t)
+
+	waitCount := func(want uint64) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for listHandlingCount(t) < want {
+			if time.Now().After(deadline) {
+				t.Fatalf("client handling samples = %d, want %d", listHandlingCount(t), want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	//收到一条消息后放弃的流在取消ctx时记录
+	before := listHandlingCount(t)
+	ctx, cancel := context.WithCancel(testContext(t))
+	cs, err := conn.NewStream(ctx, listStreamDesc, methodList)
+	if err != nil {
+		t.Fatalf("new stream: %v", err)
+	}
+	if err := cs.SendMsg(wrapperspb.String("item")); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	cs.CloseSend()
+	if err := cs.RecvMsg(&wrapperspb.StringValue{}); err != nil {
+		t.Fatalf("recv: %v", err)
+	}
+	cancel()
+	waitCount(before + 1)
+
+	//exemplar关联本次调用的trace
+	server := recorder.WaitForSpan(methodList, "server", spanWaitTimeout)
+	if server == nil {
+		t.Fatalf("server span not found, recorded: %v", recorder.Spans())
+	}
+	exemplar := `trace_id="` + server.TraceID + `"`
+	if body := scrapeOpenMetrics(t); !strings.Contains(body, exemplar) {
+		t.Fatalf("exemplar %s not found in grpc_client_handling_seconds", exemplar)
+	}
+
+	//读取到EOF的流只记录一次
+	before = listHandlingCount(t)
+	cs, err = conn.NewStream(testContext(t), listStreamDesc, methodList)
+	if err != nil {
+		t.Fatalf("new stream: %v", err)
+	}
+	cs.SendMsg(wrapperspb.String("item"))
+	cs.CloseSend()
+	for cs.RecvMsg(&wrapperspb.StringValue{}) == nil {
+	}
+	cs.RecvMsg(&wrapperspb.StringValue{})
+	waitCount(before + 1)
+	time.Sleep(50 * time.Millisecond)
+	if n := listHandlingCount(t); n != before+1 {
+		t.Fatalf("client handling samples = %d, want %d", n, before+1)
+	}
+}
@@ -0,0 +1,85 @@
+package grpctest
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	wrapper "github.com/happyhakka/grpc-wrapper/grpc"
+	"github.com/happyhakka/grpc-wrapper/trc"
+)
+
+//spanWaitTimeout 服务端span在客户端调用返回后结束，校验前等待的最长时间
+const spanWaitTimeout = 3 * time.Second
+
+var recorderSeq int64
+
+//RecordSpans 服务端与连接池开启调用链，全部采样并将span记录到返回的SpanRecorder中，需在NewServer之前调用
+//tracerType为opentracing|otel，为空时使用opentracing
+func (o *Option) RecordSpans(tracerType string) *trc.SpanRecorder {
+	if o.SysOption == nil {
+		o.SysOption = wrapper.NewGrpcSysOption()
+		o.SysOption.PromAddr = "127.0.0.1:0"
+	}
+	if o.PoolOption == nil {
+		o.PoolOption = wrapper.NewPoolOption(o.ServiceName, nil, 1, 10)
+	}
+
+	//同一服务名称的tracer在进程内共享，每个记录器使用唯一的服务名称，并行测试间互不影响
+	recorder := trc.NewSpanRecorder()
+	to := trc.NewTracerOption(fmt.Sprintf("%s-%d", o.ServiceName, atomic.AddInt64(&recorderSeq, 1)), "")
+	to.SamplerType = trc.SAMPLER_CONST
+	to.SamplerParam = 1
+	to.Recorder = recorder
+
+	//服务端与连接池使用同一配置，共享同一个tracer
+	o.SysOption.TracerFlag = true
+	o.SysOption.TracerType = tracerType
+	o.SysOption.TracerOption = to
+	o.PoolOption.TracerFlag = true
+	o.PoolOption.TracerType = tracerType
+	o.PoolOption.TracerOption = to
+	return recorder
+}
+
+//AssertRPCSpans 校验一次调用的客户端与服务端span均已结束，且服务端span为客户端span的子span
+//fullMethod如"/order.OrderService/CreateOrder"，返回客户端与服务端span
+func AssertRPCSpans(t testing.TB, r *trc.SpanRecorder, fullMethod string) (client *trc.RecordedSpan, server *trc.RecordedSpan) {
+	t.Helper()
+
+	client = r.WaitForSpan(fullMethod, "client", spanWaitTimeout)
+	if client == nil {
+		t.Fatalf("grpctest: client span %s not found, recorded: %v", fullMethod, r.Spans())
+	}
+	server = r.WaitForSpan(fullMethod, "server", spanWaitTimeout)
+	if server == nil {
+		t.Fatalf("grpctest: server span %s not found, recorded: %v", fullMethod, r.Spans())
+	}
+	if !server.IsChildOf(client) {
+		t.Fatalf("grpctest: server span %v is not child of client span %v", server, client)
+	}
+	return client, server
+}
+
+//AssertSpanTag 校验span的tag，按字符串比较以忽略opentracing与otel的数值类型差异
+func AssertSpanTag(t testing.TB, span *trc.RecordedSpan, key string, want interface{}) {
+	t.Helper()
+
+	got, ok := span.Tags[key]
+	if !ok {
+		t.Fatalf("grpctest: span %s has no tag %s, tags: %v", span.OperationName, key, span.Tags)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("grpctest: span %s tag %s = %v, want %v", span.OperationName, key, got, want)
+	}
+}
+
+//AssertSpanError 校验span是否标记为错误
+func AssertSpanError(t testing.TB, span *trc.RecordedSpan, want bool) {
+	t.Helper()
+
+	if span.Error != want {
+		t.Fatalf("grpctest: span %s error = %v, want %v", span.OperationName, span.Error, want)
+	}
+}
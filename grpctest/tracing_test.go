@@ -0,0 +1,126 @@
+package grpctest
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	wrapper "github.com/happyhakka/grpc-wrapper/grpc"
+	"github.com/happyhakka/grpc-wrapper/trc"
+
+	"github.com/opentracing/opentracing-go/ext"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRecordSpans(t *testing.T) {
+	for _, tracerType := range []string{wrapper.TRACER_TYPE_OPENTRACING, wrapper.TRACER_TYPE_OTEL} {
+		tracerType := tracerType
+		t.Run(tracerType, func(t *testing.T) {
+			//并行测试使用同一服务名称，各自的记录器互不影响
+			t.Parallel()
+
+			o := newTestOption()
+			recorder := o.RecordSpans(tracerType)
+			s, _ := newTestServer(t, o)
+			conn := s.Conn(t)
+
+			if got, err := echo(testContext(t), conn, "traced"); err != nil || got != "traced" {
+				t.Fatalf("echo = %q, %v", got, err)
+			}
+			client, server := AssertRPCSpans(t, recorder, methodEcho)
+			AssertSpanError(t, client, false)
+			AssertSpanError(t, server, false)
+			AssertSpanTag(t, server, wrapper.TAG_REQUEST_SIZE, 8)
+			AssertSpanTag(t, server, wrapper.TAG_RESPONSE_SIZE, 8)
+
+			//otel服务端span只对服务端错误(如Internal)标记错误
+			s.Faults.FailNext(1, status.Error(codes.Internal, "injected"))
+			recorder.Reset()
+			if _, err := echo(testContext(t), conn, "failed"); status.Code(err) != codes.Internal {
+				t.Fatalf("echo error = %v, want Internal", err)
+			}
+			client, server = AssertRPCSpans(t, recorder, methodEcho)
+			AssertSpanError(t, client, true)
+			AssertSpanError(t, server, true)
+
+			//每个记录器只记录本测试服务的span
+			for _, span := range recorder.Spans() {
+				if !strings.HasSuffix(span.OperationName, "grpctest.Test/Echo") {
+					t.Fatalf("unexpected span %v", span)
+				}
+			}
+		})
+	}
+}
+
+//fakeTB 记录断言失败信息，Fatalf结束当前goroutine
+type fakeTB struct {
+	testing.TB
+	mu     sync.Mutex
+	failed string
+}
+
+func (f *fakeTB) Helper() {}
+
+func (f *fakeTB) Fatalf(format string, args ...interface{}) {
+	f.mu.Lock()
+	f.failed = fmt.Sprintf(format, args...)
+	f.mu.Unlock()
+	runtime.Goexit()
+}
+
+//expectFatal 执行断言并返回失败信息，断言通过时返回空字符串
+func expectFatal(assert func(tb testing.TB)) string {
+	f := &fakeTB{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert(f)
+	}()
+	<-done
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.failed
+}
+
+func TestAssertFailures(t *testing.T) {
+	span := &trc.RecordedSpan{OperationName: "op", Tags: map[string]interface{}{"grpc.request.size": int64(8)}}
+
+	if msg := expectFatal(func(tb testing.TB) { AssertSpanTag(tb, span, "grpc.request.size", 8) }); msg != "" {
+		t.Fatalf("AssertSpanTag failed for numeric tag: %s", msg)
+	}
+	if msg := expectFatal(func(tb testing.TB) { AssertSpanTag(tb, span, "grpc.request.size", 9) }); !strings.Contains(msg, "want 9") {
+		t.Fatalf("AssertSpanTag mismatch message = %q", msg)
+	}
+	if msg := expectFatal(func(tb testing.TB) { AssertSpanTag(tb, span, "missing", 1) }); !strings.Contains(msg, "has no tag missing") {
+		t.Fatalf("AssertSpanTag missing message = %q", msg)
+	}
+	if msg := expectFatal(func(tb testing.TB) { AssertSpanError(tb, span, true) }); !strings.Contains(msg, "error = false, want true") {
+		t.Fatalf("AssertSpanError message = %q", msg)
+	}
+
+	//客户端与服务端span不是父子关系
+	recorder := trc.NewSpanRecorder()
+	o := trc.NewTracerOption(t.Name(), "")
+	o.SkipGlobal = true
+	o.Recorder = recorder
+	tracer, err := trc.AcquireTracer(o)
+	if err != nil {
+		t.Fatalf("acquire tracer: %v", err)
+	}
+	defer trc.ReleaseTracer(o.ServiceName)
+
+	tracer.StartSpan(methodEcho, ext.SpanKindRPCClient).Finish()
+	tracer.StartSpan(methodEcho, ext.SpanKindRPCServer).Finish()
+	if msg := expectFatal(func(tb testing.TB) { AssertRPCSpans(tb, recorder, methodEcho) }); !strings.Contains(msg, "is not child of client span") {
+		t.Fatalf("AssertRPCSpans message = %q", msg)
+	}
+
+	if msg := expectFatal(func(tb testing.TB) { AssertRPCSpans(tb, trc.NewSpanRecorder(), methodEcho) }); !strings.Contains(msg, "client span") {
+		t.Fatalf("AssertRPCSpans without spans message = %q", msg)
+	}
+}
@@ -0,0 +1,85 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: log/redact.proto
+
+package log
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	descriptorpb "google.golang.org/protobuf/types/descriptorpb"
+	reflect "reflect"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+var file_log_redact_proto_extTypes = []protoimpl.ExtensionInfo{
+	{
+		ExtendedType:  (*descriptorpb.FieldOptions)(nil),
+		ExtensionType: (*bool)(nil),
+		Field:         50001,
+		Name:          "grpcwrapper.log.sensitive",
+		Tag:           "varint,50001,opt,name=sensitive",
+		Filename:      "log/redact.proto",
+	},
+}
+
+// Extension fields to descriptorpb.FieldOptions.
+var (
+	//标记敏感字段，开启内容日志时该字段会被脱敏，编号需与LoggerOption.Payload.RedactOption一致
+	//  string password = 3 [(grpcwrapper.log.sensitive) = true];
+	//
+	// optional bool sensitive = 50001;
+	E_Sensitive = &file_log_redact_proto_extTypes[0]
+)
+
+var File_log_redact_proto protoreflect.FileDescriptor
+
+const file_log_redact_proto_rawDesc = "" +
+	"\n" +
+	"\x10log/redact.proto\x12\x0fgrpcwrapper.log\x1a google/protobuf/descriptor.proto:=\n" +
+	"\tsensitive\x12\x1d.google.protobuf.FieldOptions\x18ц\x03 \x01(\bR\tsensitiveB(Z&github.com/happyhakka/grpc-wrapper/logb\x06proto3"
+
+var file_log_redact_proto_goTypes = []any{
+	(*descriptorpb.FieldOptions)(nil), // 0: google.protobuf.FieldOptions
+}
+var file_log_redact_proto_depIdxs = []int32{
+	0, // 0: grpcwrapper.log.sensitive:extendee -> google.protobuf.FieldOptions
+	1, // [1:1] is the sub-list for method output_type
+	1, // [1:1] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	0, // [0:1] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_log_redact_proto_init() }
+func file_log_redact_proto_init() {
+	if File_log_redact_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_log_redact_proto_rawDesc), len(file_log_redact_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   0,
+			NumExtensions: 1,
+			NumServices:   0,
+		},
+		GoTypes:           file_log_redact_proto_goTypes,
+		DependencyIndexes: file_log_redact_proto_depIdxs,
+		ExtensionInfos:    file_log_redact_proto_extTypes,
+	}.Build()
+	File_log_redact_proto = out.File
+	file_log_redact_proto_goTypes = nil
+	file_log_redact_proto_depIdxs = nil
+}
@@ -0,0 +1,212 @@
+package log
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	OVERFLOW_BLOCK       = "block"       //队列满时阻塞等待
+	OVERFLOW_DROP_OLDEST = "drop_oldest" //队列满时丢弃最早的日志
+	OVERFLOW_DROP_NEWEST = "drop_newest" //队列满时丢弃当前日志
+
+	defaultAsyncBufferSize    = 8192
+	defaultAsyncFlushInterval = 1000
+	asyncWriteBufferSize      = 256 * 1024
+)
+
+//AsyncOption 异步写日志配置，日志先写入内存队列，由后台goroutine批量写入文件
+type AsyncOption struct {
+	BufferSize    int32  //队列长度(条)，缺省8192
+	FlushInterval int32  //刷新到文件的间隔 单位：毫秒，缺省1000
+	Overflow      string //队列满时的策略: block,drop_oldest,drop_newest，缺省block
+}
+
+var (
+	asyncQueueDepth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "log_async_queue_depth",
+			Help: "Number of log entries waiting in the async log queue.",
+		}, []string{"sink"})
+)
+
+func init() {
+	prometheus.MustRegister(asyncQueueDepth)
+}
+
+//asyncWriter 异步日志输出，调用Sync(如Log.Sync())时会等待队列中的日志全部写入，
+//Close时写入剩余日志后停止后台goroutine并关闭底层输出
+type asyncWriter struct {
+	out      zapcore.WriteSyncer
+	closer   io.Closer //底层输出(日志文件)，为空时不关闭
+	buf      *bufio.Writer
+	queue    chan []byte
+	flush    chan chan error
+	closing  chan chan error
+	done     chan struct{} //后台goroutine退出后关闭
+	once     sync.Once
+	mu       sync.RWMutex //Close时设置closed，保证关闭后不再有日志放入队列
+	closed   bool
+	interval time.Duration
+	overflow string
+
+	depth   prometheus.Gauge
+	dropped prometheus.Counter
+}
+
+//newAsyncWriter 创建异步日志输出，name用于监控指标区分不同输出，closer为Close时需关闭的底层输出
+func newAsyncWriter(out zapcore.WriteSyncer, closer io.Closer, name string, opt *AsyncOption) *asyncWriter {
+	size := int(opt.BufferSize)
+	if size <= 0 {
+		size = defaultAsyncBufferSize
+	}
+	interval := time.Duration(opt.FlushInterval) * time.Millisecond
+	if interval <= 0 {
+		interval = defaultAsyncFlushInterval * time.Millisecond
+	}
+
+	w := &asyncWriter{
+		out:      out,
+		closer:   closer,
+		buf:      bufio.NewWriterSize(out, asyncWriteBufferSize),
+		queue:    make(chan []byte, size),
+		flush:    make(chan chan error),
+		closing:  make(chan chan error),
+		done:     make(chan struct{}),
+		interval: interval,
+		overflow: strings.ToLower(opt.Overflow),
+		depth:    asyncQueueDepth.WithLabelValues(name),
+		dropped:  droppedLogs.WithLabelValues("async_overflow"),
+	}
+	go w.run()
+	return w
+}
+
+func (w *asyncWriter) Write(p []byte) (int, error) {
+	//zap写入后会复用p，需要拷贝
+	b := make([]byte, len(p))
+	copy(b, p)
+
+	//关闭后(如重新创建日志时仍在使用旧日志)丢弃并计入丢弃数
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		w.dropped.Inc()
+		return len(p), nil
+	}
+
+	switch w.overflow {
+	case OVERFLOW_DROP_NEWEST:
+		select {
+		case w.queue <- b:
+		default:
+			w.dropped.Inc()
+		}
+	case OVERFLOW_DROP_OLDEST:
+		for {
+			select {
+			case w.queue <- b:
+				w.depth.Set(float64(len(w.queue)))
+				return len(p), nil
+			default:
+			}
+			select {
+			case <-w.queue:
+				w.dropped.Inc()
+			default:
+			}
+		}
+	default:
+		select {
+		case w.queue <- b:
+		case <-w.done:
+			w.dropped.Inc()
+		}
+	}
+
+	w.depth.Set(float64(len(w.queue)))
+	return len(p), nil
+}
+
+//Sync 等待队列中的日志全部写入并同步到文件
+func (w *asyncWriter) Sync() error {
+	done := make(chan error)
+	select {
+	case w.flush <- done:
+		return <-done
+	case <-w.done:
+		return nil
+	}
+}
+
+//Close 写入队列中剩余的日志，停止后台goroutine并关闭底层输出，可重复调用
+func (w *asyncWriter) Close() error {
+	var err error
+	w.once.Do(func() {
+		//等待正在放入队列的Write完成(队列满时由后台goroutine继续写入)，之后的Write直接丢弃
+		w.mu.Lock()
+		w.closed = true
+		w.mu.Unlock()
+
+		done := make(chan error)
+		w.closing <- done
+		err = <-done
+
+		if w.closer != nil {
+			if cerr := w.closer.Close(); err == nil {
+				err = cerr
+			}
+		}
+	})
+	return err
+}
+
+func (w *asyncWriter) run() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	defer close(w.done)
+
+	for {
+		select {
+		case b := <-w.queue:
+			w.buf.Write(b)
+			w.depth.Set(float64(len(w.queue)))
+		case <-ticker.C:
+			w.buf.Flush()
+		case done := <-w.flush:
+			done <- w.sync()
+		case done := <-w.closing:
+			done <- w.sync()
+			return
+		}
+	}
+}
+
+//sync 将队列及缓冲区中的日志写入文件
+func (w *asyncWriter) sync() error {
+	w.drain()
+	err := w.buf.Flush()
+	if serr := w.out.Sync(); err == nil {
+		err = serr
+	}
+	return err
+}
+
+//drain 将队列中剩余的日志写入缓冲区
+func (w *asyncWriter) drain() {
+	for {
+		select {
+		case b := <-w.queue:
+			w.buf.Write(b)
+		default:
+			w.depth.Set(0)
+			return
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package log
+
+import (
+	"bytes"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap/zapcore"
+)
+
+//closeBuffer 记录是否被关闭的内存输出
+type closeBuffer struct {
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	closed bool
+}
+
+func (b *closeBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *closeBuffer) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	return nil
+}
+
+func (b *closeBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestAsyncWriterClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	out := &closeBuffer{}
+	w := newAsyncWriter(zapcore.AddSync(out), out, t.Name(), &AsyncOption{FlushInterval: 60000})
+	for i := 0; i < 100; i++ {
+		w.Write([]byte("line\n"))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close again: %v", err)
+	}
+
+	//Close前写入的日志全部写入底层输出并关闭
+	if got := bytes.Count([]byte(out.String()), []byte("line\n")); got != 100 {
+		t.Fatalf("written %d lines, want 100", got)
+	}
+	out.mu.Lock()
+	closed := out.closed
+	out.mu.Unlock()
+	if !closed {
+		t.Fatalf("underlying output not closed")
+	}
+
+	//关闭后写入和Sync不阻塞
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.Write([]byte("after close\n"))
+		w.Sync()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("write after close blocked")
+	}
+
+	//后台goroutine已退出
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Fatalf("goroutines = %d, want <= %d", n, before)
+	}
+}
+
+func TestAsyncWriterWriteAfterClose(t *testing.T) {
+	for _, overflow := range []string{OVERFLOW_BLOCK, OVERFLOW_DROP_OLDEST, OVERFLOW_DROP_NEWEST} {
+		out := &closeBuffer{}
+		w := newAsyncWriter(zapcore.AddSync(out), out, t.Name(), &AsyncOption{FlushInterval: 60000, Overflow: overflow})
+		w.Close()
+
+		//关闭后的日志不放入队列，计入丢弃数
+		before := testutil.ToFloat64(w.dropped)
+		for i := 0; i < 10; i++ {
+			w.Write([]byte("after close\n"))
+		}
+		if dropped := testutil.ToFloat64(w.dropped) - before; dropped != 10 {
+			t.Fatalf("%s: dropped = %v, want 10", overflow, dropped)
+		}
+		if n := len(w.queue); n != 0 {
+			t.Fatalf("%s: queue length after close = %d, want 0", overflow, n)
+		}
+	}
+}
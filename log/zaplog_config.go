@@ -0,0 +1,241 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	ENV_LOG_SERVICE = "env_log_service" //服务名称，用于文件名模板{service}
+)
+
+var (
+	serviceName string
+	rotateHook  func(string)
+
+	configTypes = map[string]bool{"json": true, "yaml": true, "yml": true, "toml": true}
+)
+
+//SetServiceName 设置服务名称，用于文件名模板{service}，需在InitLogger之前调用
+func SetServiceName(name string) {
+	serviceName = name
+}
+
+//SetRotateHook 设置按时间滚动后的回调，参数为已完成的日志文件，需在InitLogger之前调用
+func SetRotateHook(hook func(filename string)) {
+	rotateHook = hook
+}
+
+//loadLoggerOption 读取日志配置，按扩展名支持json、yaml、toml，文件不存在时使用默认配置
+func loadLoggerOption(logConfigFile string) (*LoggerOption, *viper.Viper, error) {
+	opt := &LoggerOption{}
+
+	if _, err := os.Stat(logConfigFile); err != nil {
+		if err := json.Unmarshal([]byte(defaultLogConfig), opt); err != nil {
+			return nil, nil, fmt.Errorf("default log config unmarshal fail: %v", err)
+		}
+		return opt, nil, nil
+	}
+
+	configType := strings.ToLower(strings.TrimPrefix(filepath.Ext(logConfigFile), "."))
+	if !configTypes[configType] {
+		configType = "json"
+	}
+
+	lg := viper.New()
+	lg.SetConfigFile(logConfigFile)
+	lg.SetConfigType(configType)
+	if err := lg.ReadInConfig(); err != nil {
+		return nil, nil, fmt.Errorf("read log config %s fail: %v", logConfigFile, err)
+	}
+	if err := lg.Unmarshal(opt); err != nil {
+		return nil, nil, fmt.Errorf("log config %s unmarshal fail: %v", logConfigFile, err)
+	}
+	return opt, lg, nil
+}
+
+//applyEnv 环境变量覆盖配置，并展开文件名模板
+func (o *LoggerOption) applyEnv() {
+	if lfn := os.Getenv(ENV_LOG_FILE); len(lfn) > 0 {
+		o.FilePath = lfn
+	}
+	if service := os.Getenv(ENV_LOG_SERVICE); len(service) > 0 {
+		o.Service = service
+	}
+	if o.Service == "" {
+		o.Service = serviceName
+	}
+
+	withPid := len(os.Getenv(ENV_LOG_FILE_WITH_PID)) > 0
+	o.FilePath = o.expandFilePath(o.FilePath, withPid)
+	for i := range o.Sinks {
+		o.Sinks[i].FilePath = o.expandFilePath(o.Sinks[i].FilePath, withPid)
+		o.Sinks[i].SpoolDir = o.expandFilePath(o.Sinks[i].SpoolDir, false)
+	}
+}
+
+//expandFilePath 展开文件名模板{pid},{ppid},{hostname},{service},{date}
+//withPid为true时在扩展名前追加-{pid}-{ppid}，如app.log -> app-100-1.log
+func (o *LoggerOption) expandFilePath(path string, withPid bool) string {
+	if path == "" {
+		return path
+	}
+
+	if withPid {
+		ext := filepath.Ext(path)
+		path = strings.TrimSuffix(path, ext) + "-{pid}-{ppid}" + ext
+	}
+
+	service := o.Service
+	if service == "" {
+		service = filepath.Base(os.Args[0])
+	}
+	hostname, _ := os.Hostname()
+
+	r := strings.NewReplacer(
+		"{pid}", fmt.Sprint(os.Getpid()),
+		"{ppid}", fmt.Sprint(os.Getppid()),
+		"{hostname}", hostname,
+		"{service}", service,
+		"{date}", time.Now().Format("20060102"),
+	)
+	return r.Replace(path)
+}
+
+//Validate 校验日志配置
+func (o *LoggerOption) Validate() error {
+	if err := validateLevel("Level", o.Level); err != nil {
+		return err
+	}
+	if o.MaxSize < 0 || o.MaxBackups < 0 || o.MaxAge < 0 || o.MaxTotalSize < 0 {
+		return fmt.Errorf("log config: MaxSize, MaxBackups, MaxAge and MaxTotalSize must not be negative")
+	}
+	if err := validateRotate("", o.Rotate, o.RotateInterval); err != nil {
+		return err
+	}
+	if err := validateAsync("Async", o.Async); err != nil {
+		return err
+	}
+	if len(o.Sinks) == 0 && !o.Console && o.FilePath == "" {
+		return fmt.Errorf("log config: FilePath is required when Console is false and no Sinks configured")
+	}
+
+	for i, sink := range o.Sinks {
+		name := fmt.Sprintf("Sinks[%d]", i)
+		if err := validateLevel(name+".Level", sink.Level); err != nil {
+			return err
+		}
+
+		switch strings.ToLower(sink.Encoder) {
+		case "", ENCODER_JSON, ENCODER_CONSOLE:
+		default:
+			return fmt.Errorf("log config: invalid %s.Encoder %q, expect json or console", name, sink.Encoder)
+		}
+
+		switch strings.ToLower(sink.Type) {
+		case SINK_CONSOLE:
+		case SINK_FILE:
+			if sink.FilePath == "" && o.FilePath == "" {
+				return fmt.Errorf("log config: %s.FilePath is required for file sink", name)
+			}
+			if err := validateRotate(name+".", sink.Rotate, sink.RotateInterval); err != nil {
+				return err
+			}
+			if err := validateAsync(name+".Async", sink.Async); err != nil {
+				return err
+			}
+		case SINK_SYSLOG:
+			switch strings.ToLower(sink.Network) {
+			case "", "udp", "tcp", "unix":
+			default:
+				return fmt.Errorf("log config: invalid %s.Network %q, expect udp, tcp or unix", name, sink.Network)
+			}
+			if _, ok := syslogFacilities[strings.ToLower(sink.Facility)]; sink.Facility != "" && !ok {
+				return fmt.Errorf("log config: invalid %s.Facility %q", name, sink.Facility)
+			}
+		case SINK_TCP, SINK_HTTP:
+			if sink.Address == "" {
+				return fmt.Errorf("log config: %s.Address is required for %s sink", name, sink.Type)
+			}
+		default:
+			return fmt.Errorf("log config: invalid %s.Type %q, expect console, file, syslog, tcp or http", name, sink.Type)
+		}
+	}
+
+	for module, level := range o.Levels {
+		if err := validateLevel("Levels."+module, level); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateLevel(name string, level string) error {
+	var l zapcore.Level
+	if err := l.Set(strings.ToLower(level)); err != nil {
+		return fmt.Errorf("log config: invalid %s %q, expect one of debug,info,warn,error,dpanic,panic,fatal", name, level)
+	}
+	return nil
+}
+
+func validateRotate(prefix string, rotate string, interval string) error {
+	switch strings.ToLower(rotate) {
+	case "", ROTATE_SIZE, ROTATE_TIME:
+	default:
+		return fmt.Errorf("log config: invalid %sRotate %q, expect size or time", prefix, rotate)
+	}
+	switch strings.ToLower(interval) {
+	case "", ROTATE_HOURLY, ROTATE_DAILY:
+	default:
+		return fmt.Errorf("log config: invalid %sRotateInterval %q, expect hour or day", prefix, interval)
+	}
+	return nil
+}
+
+func validateAsync(name string, async *AsyncOption) error {
+	if async == nil {
+		return nil
+	}
+	switch strings.ToLower(async.Overflow) {
+	case "", OVERFLOW_BLOCK, OVERFLOW_DROP_OLDEST, OVERFLOW_DROP_NEWEST:
+	default:
+		return fmt.Errorf("log config: invalid %s.Overflow %q, expect block, drop_oldest or drop_newest", name, async.Overflow)
+	}
+	return nil
+}
+
+//watchLoggerConfig 监听配置文件变化，级别、模块级别、调用日志规则与内容日志配置实时生效，
+//配置文件中删除的模块级别恢复使用全局级别
+//输出(Sinks、文件路径等)的修改需重启后生效
+func watchLoggerConfig(lg *viper.Viper) {
+	lg.OnConfigChange(func(e fsnotify.Event) {
+		opt := &LoggerOption{}
+		if err := lg.Unmarshal(opt); err != nil {
+			fmt.Println("log config unmarshal fail!", err)
+			return
+		}
+		if err := opt.Validate(); err != nil {
+			fmt.Println("log config reload fail!", err)
+			return
+		}
+
+		SetLogLevel(opt.Level)
+		setConfigLevels(opt.Levels)
+		setCallLogRules(opt.CallLogRules)
+		setPayloadOption(opt.Payload)
+
+		if Log != nil {
+			Log.Info("log config reloaded.", zap.String("LogLevel", opt.Level), zap.String("file", e.Name))
+		}
+	})
+	lg.WatchConfig()
+}
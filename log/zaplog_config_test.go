@@ -0,0 +1,124 @@
+package log
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("write log config: %v", err)
+	}
+}
+
+//waitFor 等待配置文件变化生效
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestWatchLoggerConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "log.json")
+	writeConfig(t, path, `{
+		"FilePath": "`+filepath.Join(dir, "app.log")+`",
+		"Level": "info",
+		"Watch": true,
+		"Levels": {"watchpool": "debug", "watchgrpc": "warn"},
+		"CallLogRules": [{"Method": "/grpc.health.v1.Health/*", "Skip": true}]
+	}`)
+
+	if _, err := InitLogger(path); err != nil {
+		t.Fatalf("init logger: %v", err)
+	}
+	t.Cleanup(func() {
+		setConfigLevels(nil)
+		setCallLogRules(nil)
+	})
+
+	if levels := NamedLevels(); levels["watchpool"] != "debug" || levels["watchgrpc"] != "warn" {
+		t.Fatalf("named levels = %v", levels)
+	}
+	if ShouldLogCall("/grpc.health.v1.Health/Check", nil, 0) {
+		t.Fatalf("health check call should be skipped")
+	}
+
+	//删除watchgrpc的级别及调用日志规则
+	writeConfig(t, path, `{
+		"FilePath": "`+filepath.Join(dir, "app.log")+`",
+		"Level": "info",
+		"Watch": true,
+		"Levels": {"watchpool": "error"}
+	}`)
+	waitFor(t, "config reload", func() bool {
+		return NamedLevels()["watchpool"] == "error"
+	})
+
+	if level, ok := NamedLevels()["watchgrpc"]; ok {
+		t.Fatalf("removed module level still set: %s", level)
+	}
+	if !ShouldLogCall("/grpc.health.v1.Health/Check", nil, 0) {
+		t.Fatalf("removed call log rule still applied")
+	}
+}
+
+func TestSetRotateHook(t *testing.T) {
+	rotated := make(chan string, 1)
+	SetRotateHook(func(filename string) { rotated <- filename })
+	t.Cleanup(func() {
+		SetRotateHook(nil)
+		Close()
+	})
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "log.json")
+	writeConfig(t, path, `{
+		"FilePath": "`+filepath.Join(dir, "app.log")+`",
+		"Level": "info",
+		"Rotate": "time",
+		"RotateInterval": "hour"
+	}`)
+	if _, err := InitLogger(path); err != nil {
+		t.Fatalf("init logger: %v", err)
+	}
+	Log.Info("before rotate")
+
+	var w *timeRotateWriter
+	logClosersMu.Lock()
+	for _, c := range logClosers {
+		if rw, ok := c.(*timeRotateWriter); ok {
+			w = rw
+		}
+	}
+	logClosersMu.Unlock()
+	if w == nil {
+		t.Fatalf("time rotate writer not found")
+	}
+
+	//模拟进入下一个小时
+	w.mu.Lock()
+	prev := w.file.Name()
+	err := w.rotate(time.Now().Add(time.Hour))
+	w.mu.Unlock()
+	if err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+
+	select {
+	case name := <-rotated:
+		if name != prev {
+			t.Fatalf("rotate hook file = %s, want %s", name, prev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("rotate hook not called")
+	}
+}
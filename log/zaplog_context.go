@@ -0,0 +1,71 @@
+package log
+
+import (
+	"context"
+
+	"github.com/happyhakka/grpc-wrapper/trc"
+
+	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+)
+
+//FromContext 返回带有调用上下文字段的日志对象
+//自动添加trace_id、span_id、grpc.method、peer.address、grpc_ctxtags中的字段(如request_id)及配置的baggage(trc.SetBaggageKeys)
+func FromContext(ctx context.Context) *zap.Logger {
+	logger := Log
+	if logger == nil {
+		logger = zap.L()
+	}
+	return logger.With(ContextFields(ctx)...)
+}
+
+//FromContextf 返回带有调用上下文字段的自由格式日志对象
+func FromContextf(ctx context.Context) *zap.SugaredLogger {
+	return FromContext(ctx).Sugar()
+}
+
+//ContextFields 获取调用上下文中的日志字段
+func ContextFields(ctx context.Context) []zap.Field {
+	fields := make([]zap.Field, 0)
+	if ctx == nil {
+		return fields
+	}
+
+	if traceID := trc.TraceID(ctx); traceID != "" {
+		fields = append(fields, zap.String("trace_id", traceID))
+	}
+	if spanID := trc.SpanID(ctx); spanID != "" {
+		fields = append(fields, zap.String("span_id", spanID))
+	}
+	if method, ok := grpc.Method(ctx); ok {
+		fields = append(fields, zap.String("grpc.method", method))
+	}
+	if pr, ok := peer.FromContext(ctx); ok && pr.Addr != nil {
+		fields = append(fields, zap.String("peer.address", pr.Addr.String()))
+	}
+	//grpc_ctxtags同样会设置peer.address，跳过已添加的字段
+	for k, v := range grpc_ctxtags.Extract(ctx).Values() {
+		if hasField(fields, k) {
+			continue
+		}
+		fields = append(fields, zap.Any(k, v))
+	}
+	for _, field := range trc.BaggageFields(ctx) {
+		if hasField(fields, field.Key) {
+			continue
+		}
+		fields = append(fields, zap.String(field.Key, field.Value))
+	}
+	return fields
+}
+
+func hasField(fields []zap.Field, key string) bool {
+	for _, f := range fields {
+		if f.Key == key {
+			return true
+		}
+	}
+	return false
+}
@@ -1,14 +1,14 @@
 package log
 
 import (
-	"encoding/json"
 	"fmt"
-	"os"
+	"io"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/natefinch/lumberjack"
-	"github.com/spf13/viper"
+	"github.com/happyhakka/grpc-wrapper/trc"
+
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
@@ -21,54 +21,80 @@ const (
 var (
 	Log         *zap.Logger
 	Logf        *zap.SugaredLogger
-	atomicLevel zap.AtomicLevel
+	atomicLevel = zap.NewAtomicLevel() //全局级别，重新创建日志时只修改级别，已创建的日志继续使用
+
+	logClosersMu sync.Mutex
+	logClosers   []io.Closer //当前日志的文件等输出，重新创建日志或Close时关闭
 )
 
 type LoggerOption struct {
-	FilePath   string //日志文件路径
+	FilePath   string //日志文件路径，支持模板{pid},{ppid},{hostname},{service},{date}
 	Level      string //日志级别: debug,info,warn,error,panic,fatal
 	MaxSize    int32  //每个日志文件保存的最大尺寸 单位：M
 	MaxBackups int32  //日志文件最多保存多少个备份
 	MaxAge     int32  //文件最多保存多少天
 	Compress   bool   //是否压缩
 	Console    bool   //是否打印到屏幕上，缺少为不打印false
-}
 
-func NewLogger(opt *LoggerOption) *zap.Logger {
-	var level zapcore.Level
-	level.Set(strings.ToLower(opt.Level))
-	return buildLogger(opt.FilePath, level, int(opt.MaxSize), int(opt.MaxBackups), int(opt.MaxAge), opt.Compress, opt.Console)
-}
+	Rotate         string       //滚动方式: size(按大小),time(按时间)，缺省为size
+	RotateInterval string       //按时间滚动的周期: hour,day，缺省为day
+	MaxTotalSize   int32        //按时间滚动时所有日志文件总大小上限 单位：M，0为不限制
+	RotateHook     func(string) `json:"-"` //按时间滚动后的回调，参数为已完成的日志文件
 
-//   NewLogger 获取日志
-//   filePath 日志文件路径
-//   level 日志级别
-//   maxSize 每个日志文件保存的最大尺寸 单位：M
-//   maxBackups 日志文件最多保存多少个备份
-//   maxAge 文件最多保存多少天
-//   compress 是否压缩
-//   serviceName 服务名
-func buildLogger(filePath string, level zapcore.Level, maxSize int, maxBackups int, maxAge int, compress bool, console bool) *zap.Logger {
-	core := newCore(filePath, level, maxSize, maxBackups, maxAge, compress, console)
-	return zap.New(core, zap.AddCaller(), zap.Development())
+	Sinks []SinkOption //多个日志输出(控制台、文件、错误日志文件等)，为空时按Console/FilePath输出
+
+	Sampling     *SamplingOption //日志采样，为空时不采样
+	CallLogRules []CallLogRule   //grpc调用日志规则，如跳过健康检查、只记录出错或慢调用
+
+	Payload *PayloadLogOption //请求与应答内容日志，为空时不记录
+
+	Async *AsyncOption //文件输出异步写入，为空时同步写入
+
+	Levels map[string]string //模块日志级别，如{"grpc": "warn", "pool": "debug"}，见Named
+
+	Ring *RingOption //内存日志缓存，可通过admin端口/debug/logs查询，为空时不缓存
+
+	Service string //服务名称，用于文件名模板{service}
+	Watch   bool   //是否监听配置文件变化，级别等配置实时生效
 }
 
-//  newCore 构造日志模块
-func newCore(filePath string, level zapcore.Level, maxSize int, maxBackups int, maxAge int, compress bool, console bool) zapcore.Core {
-	//日志文件路径配置2
-	hook := lumberjack.Logger{
-		Filename:   filePath,   // 日志文件路径
-		MaxSize:    maxSize,    // 每个日志文件保存的最大尺寸 单位：M
-		MaxBackups: maxBackups, // 日志文件最多保存多少个备份
-		MaxAge:     maxAge,     // 文件最多保存多少天
-		Compress:   compress,   // 是否压缩
+//NewLogger 按配置创建日志，配置需先通过Validate校验
+func NewLogger(opt *LoggerOption) *zap.Logger {
+	var level zapcore.Level
+	if err := level.Set(strings.ToLower(opt.Level)); err != nil {
+		fmt.Printf("invalid log level %q, use info! error<%v>\n", opt.Level, err)
+		level = zapcore.InfoLevel
 	}
+
 	// 设置日志级别
-	atomicLevel = zap.NewAtomicLevel()
 	atomicLevel.SetLevel(level)
 
-	//公用编码器
-	encoderConfig := zapcore.EncoderConfig{
+	setCallLogRules(opt.CallLogRules)
+	setPayloadOption(opt.Payload)
+	setConfigLevels(opt.Levels)
+
+	//级别在最外层过滤，模块日志可使用与全局不同的级别
+	cores, closers := newSinkCores(opt)
+	var ring *ringBuffer
+	if opt.Ring != nil {
+		ring = newRingBuffer(opt.Ring)
+		cores = append(cores, &ringCore{ring: ring})
+	}
+	logRing.Store(ring)
+
+	base := newSampler(zapcore.NewTee(cores...), opt.Sampling)
+	setBaseCore(base)
+	trc.SetLogger(Named("trc"))
+
+	//已创建的日志切换到新的输出后，关闭上次创建的日志文件及异步写入goroutine
+	setLogClosers(closers)
+	core := &levelCore{Core: &swapCore{}, level: atomicLevel}
+	return zap.New(core, zap.AddCaller(), zap.Development())
+}
+
+//  newEncoderConfig 公用编码器配置
+func newEncoderConfig() zapcore.EncoderConfig {
+	return zapcore.EncoderConfig{
 		TimeKey:        "T",
 		LevelKey:       "L",
 		NameKey:        "N",
@@ -81,29 +107,56 @@ func newCore(filePath string, level zapcore.Level, maxSize int, maxBackups int,
 		EncodeDuration: zapcore.SecondsDurationEncoder, //
 		EncodeCaller:   zapcore.ShortCallerEncoder,     //zapcore.FullCallerEncoder
 	}
+}
+
+//Sync 将缓冲及异步队列中的日志写入文件，进程退出前调用
+func Sync() error {
+	if Log == nil {
+		return nil
+	}
+	return Log.Sync()
+}
+
+//setLogClosers 记录当前日志的输出，关闭上次创建的输出
+func setLogClosers(closers []io.Closer) {
+	logClosersMu.Lock()
+	previous := logClosers
+	logClosers = closers
+	logClosersMu.Unlock()
+
+	closeAll(previous)
+}
 
-	if console {
-		return zapcore.NewCore(
-			zapcore.NewJSONEncoder(encoderConfig),
-			zapcore.NewMultiWriteSyncer(zapcore.AddSync(os.Stdout)), // 只打印到控制台
-			atomicLevel, // 日志级别
-		)
-	} else {
-		return zapcore.NewCore(
-			zapcore.NewJSONEncoder(encoderConfig),
-			zapcore.NewMultiWriteSyncer(zapcore.AddSync(&hook)), // 打印到控制台和文件
-			atomicLevel, // 日志级别
-		)
+//Close 将异步队列中的日志写入文件，停止异步写入并关闭日志文件，进程退出前调用
+func Close() error {
+	logClosersMu.Lock()
+	closers := logClosers
+	logClosers = nil
+	logClosersMu.Unlock()
+
+	return closeAll(closers)
+}
+
+func closeAll(closers []io.Closer) error {
+	var err error
+	for _, c := range closers {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
 	}
+	return err
 }
 
-func SetLogLevel(strLevel string) {
+func SetLogLevel(strLevel string) error {
 	var level zapcore.Level
-	level.Set(strings.ToLower(strLevel))
+	if err := level.Set(strings.ToLower(strLevel)); err != nil {
+		return fmt.Errorf("invalid log level %q: %v", strLevel, err)
+	}
 
 	if Log != nil {
 		atomicLevel.SetLevel(level)
 	}
+	return nil
 }
 
 // TcLoggerTimeEncoder serializes a time.Time to an ISO8601-formatted string
@@ -128,37 +181,27 @@ const (
 )
 
 func InitLogger(logConfigFile string) (*zap.Logger, error) {
-	opt := &LoggerOption{}
-
-	_, err := os.Stat(logConfigFile) //Stat获取文件属性
+	opt, lg, err := loadLoggerOption(logConfigFile)
 	if err != nil {
-		json.Unmarshal([]byte(defaultLogConfig), opt)
-	} else {
-		lg := viper.New()
-		lg.SetConfigFile(logConfigFile)
-		lg.SetConfigType("json")
-		if err := lg.ReadInConfig(); err != nil {
-			fmt.Println("read log.json file fail!", err)
-			return nil, err
-		}
-		if err := lg.Unmarshal(opt); err != nil {
-			fmt.Println("log.json unmarshal fail!", err)
-			return nil, err
-		}
+		fmt.Println(err)
+		return nil, err
 	}
 
-	lfn := os.Getenv(ENV_LOG_FILE)
-	if len(lfn) > 0 {
-		opt.FilePath = lfn
+	opt.applyEnv()
+	if err := opt.Validate(); err != nil {
+		fmt.Println(err)
+		return nil, err
 	}
-
-	if len(os.Getenv(ENV_LOG_FILE_WITH_PID)) > 0 {
-		opt.FilePath = strings.Replace(opt.FilePath, ".log", fmt.Sprintf("-%d-%d.log", os.Getpid(), os.Getppid()), -1)
+	if opt.RotateHook == nil {
+		opt.RotateHook = rotateHook
 	}
 
 	Log = NewLogger(opt)
 	Logf = Log.Sugar()
 	Log.Info("log init ok.", zap.String("LogLevel", opt.Level), zap.String("FilePath", opt.FilePath))
 
+	if lg != nil && opt.Watch {
+		watchLoggerConfig(lg)
+	}
 	return Log, nil
 }
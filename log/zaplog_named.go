@@ -0,0 +1,202 @@
+package log
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+var (
+	baseCore    atomic.Value //*coreBox，不带全局级别过滤的日志模块，由Log与各模块日志通过swapCore共用
+	namedLevels sync.Map     //模块名 -> zap.AtomicLevel
+
+	namedMu      sync.Mutex
+	namedLoggers = make(map[string]*zap.Logger) //模块名 -> 模块日志
+	configLevels = make(map[string]bool)        //配置文件Levels中设置了级别的模块
+)
+
+//coreBox 包装日志模块，zapcore.NewTee等返回的模块不可比较，替换时按指针区分
+type coreBox struct {
+	core zapcore.Core
+}
+
+//loadBaseCore 当前共用的日志模块，未创建日志时为nil
+func loadBaseCore() *coreBox {
+	box, _ := baseCore.Load().(*coreBox)
+	return box
+}
+
+//swapCore 写入当前共用日志模块的日志模块，重新创建日志时已获取的日志(包括With创建的子日志)写入新的输出，
+//旧的输出关闭后不会再被使用
+type swapCore struct {
+	fields []zapcore.Field
+	cache  atomic.Value //*swapState
+}
+
+//swapState 共用日志模块及附加fields后的日志模块，共用日志模块替换后重新生成
+type swapState struct {
+	base *coreBox
+	core zapcore.Core
+}
+
+func (c *swapCore) current() zapcore.Core {
+	base := loadBaseCore()
+	if base == nil {
+		return zapcore.NewNopCore()
+	}
+	if st, ok := c.cache.Load().(*swapState); ok && st.base == base {
+		return st.core
+	}
+	core := base.core
+	if len(c.fields) > 0 {
+		core = core.With(c.fields)
+	}
+	c.cache.Store(&swapState{base: base, core: core})
+	return core
+}
+
+func (c *swapCore) Enabled(l zapcore.Level) bool {
+	return c.current().Enabled(l)
+}
+
+func (c *swapCore) With(fields []zapcore.Field) zapcore.Core {
+	all := make([]zapcore.Field, 0, len(c.fields)+len(fields))
+	all = append(all, c.fields...)
+	all = append(all, fields...)
+	return &swapCore{fields: all}
+}
+
+func (c *swapCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return c.current().Check(ent, ce)
+}
+
+func (c *swapCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	return c.current().Write(ent, fields)
+}
+
+func (c *swapCore) Sync() error {
+	return c.current().Sync()
+}
+
+//levelCore 在日志模块外层按指定级别过滤
+type levelCore struct {
+	zapcore.Core
+	level zapcore.LevelEnabler
+}
+
+func (c *levelCore) Enabled(l zapcore.Level) bool {
+	return c.level.Enabled(l)
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields), level: c.level}
+}
+
+func (c *levelCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.level.Enabled(ent.Level) {
+		return ce
+	}
+	return c.Core.Check(ent, ce)
+}
+
+//namedEnabler 模块日志级别，未单独设置时依次使用上级模块的级别与全局级别
+//如pool.conn未设置时使用pool的级别
+type namedEnabler string
+
+func (n namedEnabler) Enabled(l zapcore.Level) bool {
+	name := string(n)
+	for name != "" {
+		if v, ok := namedLevels.Load(name); ok {
+			return v.(zap.AtomicLevel).Enabled(l)
+		}
+		i := strings.LastIndex(name, ".")
+		if i < 0 {
+			break
+		}
+		name = name[:i]
+	}
+	return atomicLevel.Enabled(l)
+}
+
+//Named 获取模块日志，各模块级别可在log.json的Levels中配置或通过SetNamedLevel修改
+//  Named("pool").Debug("get conn", zap.String("target", target))
+func Named(name string) *zap.Logger {
+	name = strings.ToLower(name)
+
+	namedMu.Lock()
+	defer namedMu.Unlock()
+
+	if loadBaseCore() == nil {
+		return zap.L().Named(name)
+	}
+	if logger, ok := namedLoggers[name]; ok {
+		return logger
+	}
+	logger := zap.New(&levelCore{Core: &swapCore{}, level: namedEnabler(name)}, zap.AddCaller(), zap.Development()).Named(name)
+	namedLoggers[name] = logger
+	return logger
+}
+
+//setBaseCore 替换共用的日志模块，已创建的日志写入新的日志模块
+func setBaseCore(core zapcore.Core) {
+	baseCore.Store(&coreBox{core: core})
+}
+
+//SetNamedLevel 运行时修改模块日志级别，level为空时恢复使用全局级别
+func SetNamedLevel(name string, level string) error {
+	name = strings.ToLower(name)
+	if level == "" {
+		namedLevels.Delete(name)
+		return nil
+	}
+
+	var l zapcore.Level
+	if err := l.Set(strings.ToLower(level)); err != nil {
+		return fmt.Errorf("invalid log level %q for %s: %v", level, name, err)
+	}
+
+	if v, ok := namedLevels.Load(name); ok {
+		v.(zap.AtomicLevel).SetLevel(l)
+		return nil
+	}
+	namedLevels.Store(name, zap.NewAtomicLevelAt(l))
+	return nil
+}
+
+//setConfigLevels 按配置文件的Levels设置模块级别，上次配置中有而本次配置中没有的模块恢复使用全局级别
+func setConfigLevels(levels map[string]string) {
+	current := make(map[string]bool, len(levels))
+	for name := range levels {
+		current[strings.ToLower(name)] = true
+	}
+
+	namedMu.Lock()
+	previous := configLevels
+	configLevels = current
+	namedMu.Unlock()
+
+	for name := range previous {
+		if !current[name] {
+			SetNamedLevel(name, "")
+		}
+	}
+	for name, level := range levels {
+		if err := SetNamedLevel(name, level); err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+//NamedLevels 当前单独设置了级别的模块
+func NamedLevels() map[string]string {
+	levels := make(map[string]string)
+	namedLevels.Range(func(k, v interface{}) bool {
+		levels[k.(string)] = v.(zap.AtomicLevel).String()
+		return true
+	})
+	return levels
+}
@@ -0,0 +1,71 @@
+package log
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+//TestLoggerRebuild 重新创建日志后，之前获取的日志写入新的输出
+func TestLoggerRebuild(t *testing.T) {
+	t.Cleanup(func() { Close() })
+
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.log")
+	second := filepath.Join(dir, "second.log")
+	newOption := func(path string) *LoggerOption {
+		return &LoggerOption{Level: "info", FilePath: path, MaxSize: 1, Async: &AsyncOption{FlushInterval: 60000}}
+	}
+
+	logger := NewLogger(newOption(first))
+	named := Named("rebuild")
+	child := named.With(zap.String("request_id", "r1"))
+
+	NewLogger(newOption(second))
+	logger.Info("root after rebuild")
+	named.Info("named after rebuild")
+	child.Info("child after rebuild")
+	if err := logger.Sync(); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(second)
+	if err != nil {
+		t.Fatalf("read log: %v", err)
+	}
+	for _, msg := range []string{"root after rebuild", "named after rebuild", "child after rebuild", `"request_id":"r1"`} {
+		if !strings.Contains(string(data), msg) {
+			t.Fatalf("log after rebuild missing %q: %s", msg, data)
+		}
+	}
+}
+
+//TestLoggerRebuildRace 重新创建日志与写日志并发进行，需配合-race运行
+func TestLoggerRebuildRace(t *testing.T) {
+	t.Cleanup(func() { Close() })
+
+	opt := &LoggerOption{Level: "info", FilePath: filepath.Join(t.TempDir(), "race.log"), MaxSize: 1}
+	NewLogger(opt)
+	named := Named("rebuild.race")
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			NewLogger(opt)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 500; i++ {
+			named.Info("race")
+			named.Core().Enabled(0)
+		}
+	}()
+	wg.Wait()
+}
@@ -0,0 +1,209 @@
+package log
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	protov2 "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const (
+	//defaultRedactOption 标记敏感字段的自定义字段选项编号，见redact.proto
+	defaultRedactOption = 50001
+
+	redactedValue = "***"
+	truncatedMark = "...(truncated)"
+)
+
+//PayloadLogOption 请求与应答内容日志配置，内容以protobuf json格式记录
+type PayloadLogOption struct {
+	Methods      []string //记录内容的方法全名，以*结尾时按前缀匹配，为空时不记录
+	MaxSize      int32    //单条内容日志最大字节数，超过时截断，0为不限制
+	RedactFields []string //需脱敏的字段，可写字段名(如password)或字段全名(如order.OrderRequest.orderId)
+	RedactOption int32    //标记敏感字段的自定义字段选项编号，缺省50001
+}
+
+//payloadState 内容日志配置及字段脱敏缓存，配置重新加载时整体替换
+type payloadState struct {
+	opt         *PayloadLogOption
+	redactCache sync.Map //protoreflect.FieldDescriptor -> bool
+}
+
+var (
+	payloadConfig atomic.Value //*payloadState，未配置时opt为空
+
+	defaultPayloadState = &payloadState{opt: &PayloadLogOption{RedactOption: defaultRedactOption}}
+)
+
+//setPayloadOption 更新内容日志配置，可在拦截器读取时并发调用
+func setPayloadOption(opt *PayloadLogOption) {
+	st := &payloadState{}
+	if opt != nil {
+		o := *opt
+		if o.RedactOption <= 0 {
+			o.RedactOption = defaultRedactOption
+		}
+		st.opt = &o
+	}
+	payloadConfig.Store(st)
+}
+
+func loadPayloadState() *payloadState {
+	st, _ := payloadConfig.Load().(*payloadState)
+	return st
+}
+
+//ShouldLogPayload 该方法是否记录请求与应答内容
+func ShouldLogPayload(method string) bool {
+	st := loadPayloadState()
+	if st == nil || st.opt == nil {
+		return false
+	}
+	opt := st.opt
+
+	for _, m := range opt.Methods {
+		if m == "*" || m == method {
+			return true
+		}
+		if strings.HasSuffix(m, "*") && strings.HasPrefix(method, strings.TrimSuffix(m, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+//PayloadString 将protobuf消息转为json，敏感字段脱敏，超过MaxSize时截断
+func PayloadString(msg interface{}) string {
+	st := loadPayloadState()
+	if st == nil || st.opt == nil {
+		st = defaultPayloadState
+	}
+
+	pm, ok := msg.(proto.GeneratedMessage)
+	if !ok || pm == nil {
+		return ""
+	}
+	m := proto.MessageV2(pm)
+	if m == nil {
+		return ""
+	}
+
+	//脱敏在副本上进行，不影响实际收发的消息
+	m = protov2.Clone(m)
+	redactMessage(m.ProtoReflect(), st)
+
+	b, err := protojson.Marshal(m)
+	if err != nil {
+		return ""
+	}
+
+	s := string(b)
+	if max := int(st.opt.MaxSize); max > 0 && len(s) > max {
+		//在字符边界截断，避免输出不完整的UTF-8字符
+		for max > 0 && !utf8.RuneStart(s[max]) {
+			max--
+		}
+		s = s[:max] + truncatedMark
+	}
+	return s
+}
+
+//redactMessage 递归处理消息中的敏感字段，字符串替换为***，其余类型清空
+func redactMessage(m protoreflect.Message, st *payloadState) {
+	sensitive := make([]protoreflect.FieldDescriptor, 0)
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if isSensitive(fd, st) {
+			sensitive = append(sensitive, fd)
+			return true
+		}
+
+		switch {
+		case fd.IsList() && fd.Message() != nil:
+			list := v.List()
+			for i := 0; i < list.Len(); i++ {
+				redactMessage(list.Get(i).Message(), st)
+			}
+		case fd.IsMap() && fd.MapValue().Message() != nil:
+			v.Map().Range(func(k protoreflect.MapKey, mv protoreflect.Value) bool {
+				redactMessage(mv.Message(), st)
+				return true
+			})
+		case !fd.IsList() && !fd.IsMap() && fd.Message() != nil:
+			redactMessage(v.Message(), st)
+		}
+		return true
+	})
+
+	for _, fd := range sensitive {
+		if fd.Kind() == protoreflect.StringKind && !fd.IsList() && !fd.IsMap() {
+			m.Set(fd, protoreflect.ValueOfString(redactedValue))
+		} else {
+			m.Clear(fd)
+		}
+	}
+}
+
+//isSensitive 字段是否需要脱敏: 在RedactFields中配置或带有RedactOption字段选项
+func isSensitive(fd protoreflect.FieldDescriptor, st *payloadState) bool {
+	for _, name := range st.opt.RedactFields {
+		if name == string(fd.Name()) || name == string(fd.FullName()) || name == fd.JSONName() {
+			return true
+		}
+	}
+
+	if v, ok := st.redactCache.Load(fd); ok {
+		return v.(bool)
+	}
+	sensitive := hasRedactOption(fd, protowire.Number(st.opt.RedactOption))
+	st.redactCache.Store(fd, sensitive)
+	return sensitive
+}
+
+//hasRedactOption 字段选项中是否设置了[(sensitive) = true]
+//未注册该扩展时选项保存在unknown字段中，直接按编号解析
+func hasRedactOption(fd protoreflect.FieldDescriptor, num protowire.Number) bool {
+	opts, ok := fd.Options().(*descriptorpb.FieldOptions)
+	if !ok || opts == nil {
+		return false
+	}
+
+	found := false
+	opts.ProtoReflect().Range(func(xd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if xd.IsExtension() && xd.Number() == num && xd.Kind() == protoreflect.BoolKind {
+			found = v.Bool()
+			return false
+		}
+		return true
+	})
+	if found {
+		return true
+	}
+
+	b := opts.ProtoReflect().GetUnknown()
+	for len(b) > 0 {
+		n, typ, l := protowire.ConsumeTag(b)
+		if l < 0 {
+			return false
+		}
+		b = b[l:]
+
+		if n == num && typ == protowire.VarintType {
+			v, l := protowire.ConsumeVarint(b)
+			return l >= 0 && v != 0
+		}
+
+		l = protowire.ConsumeFieldValue(n, typ, b)
+		if l < 0 {
+			return false
+		}
+		b = b[l:]
+	}
+	return false
+}
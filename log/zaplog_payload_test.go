@@ -0,0 +1,99 @@
+package log
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestPayloadTruncateUTF8(t *testing.T) {
+	t.Cleanup(func() { setPayloadOption(nil) })
+
+	msg := wrapperspb.String(strings.Repeat("脱敏", 20))
+	for size := int32(1); size < 20; size++ {
+		setPayloadOption(&PayloadLogOption{MaxSize: size})
+		s := PayloadString(msg)
+		if !strings.HasSuffix(s, truncatedMark) {
+			t.Fatalf("MaxSize %d: %q not truncated", size, s)
+		}
+		content := strings.TrimSuffix(s, truncatedMark)
+		if len(content) > int(size) || !utf8.ValidString(content) {
+			t.Fatalf("MaxSize %d: invalid truncated content %q", size, content)
+		}
+	}
+}
+
+//newSensitiveMessage 动态创建带有[(grpcwrapper.log.sensitive) = true]字段的消息
+func newSensitiveMessage(t *testing.T) protoreflect.Message {
+	opts := &descriptorpb.FieldOptions{}
+	proto.SetExtension(opts, E_Sensitive, true)
+
+	fdp := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("payload_test.proto"),
+		Package: proto.String("grpcwrapper.test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("LoginRequest"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				{Name: proto.String("user"), JsonName: proto.String("user"), Number: proto.Int32(1), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()},
+				{Name: proto.String("password"), JsonName: proto.String("password"), Number: proto.Int32(2), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(), Options: opts},
+			},
+		}},
+	}
+	fd, err := protodesc.NewFile(fdp, nil)
+	if err != nil {
+		t.Fatalf("new file descriptor: %v", err)
+	}
+
+	m := dynamicpb.NewMessage(fd.Messages().Get(0))
+	m.Set(m.Descriptor().Fields().ByName("user"), protoreflect.ValueOfString("alice"))
+	m.Set(m.Descriptor().Fields().ByName("password"), protoreflect.ValueOfString("secret"))
+	return m
+}
+
+func TestPayloadRedactOption(t *testing.T) {
+	t.Cleanup(func() { setPayloadOption(nil) })
+	setPayloadOption(&PayloadLogOption{Methods: []string{"*"}})
+
+	s := PayloadString(newSensitiveMessage(t))
+	if strings.Contains(s, "secret") || !strings.Contains(s, redactedValue) || !strings.Contains(s, "alice") {
+		t.Fatalf("payload = %s, want password redacted", s)
+	}
+}
+
+//TestPayloadOptionReload 重新加载配置与读取并发进行，需配合-race运行
+func TestPayloadOptionReload(t *testing.T) {
+	t.Cleanup(func() { setPayloadOption(nil) })
+
+	msg := wrapperspb.String("payload")
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				setPayloadOption(&PayloadLogOption{Methods: []string{"/order.OrderService/*"}, RedactFields: []string{"value"}})
+				setPayloadOption(nil)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				ShouldLogPayload("/order.OrderService/Get")
+				if s := PayloadString(msg); s != `"payload"` && s != `"***"` {
+					t.Errorf("unexpected payload %s", s)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
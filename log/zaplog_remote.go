@@ -0,0 +1,373 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	SINK_TCP  = "tcp"  //按行发送json到tcp服务
+	SINK_HTTP = "http" //批量POST按行分隔的json到http服务
+
+	defaultBatchSize     = 100
+	defaultBatchInterval = 1000
+	defaultRetryTimes    = 3
+	defaultSpoolMaxSize  = 32
+	remoteQueueSize      = 8192
+	remoteTimeout        = 5 * time.Second
+	remoteSyncTimeout    = 3 * time.Second //Sync/Close等待发送的最长时间，超时未发送的日志写入本地缓存
+	spoolRetryBackoff    = time.Second     //补发本地缓存失败后的等待时间，连续失败时翻倍
+	spoolMaxRetryBackoff = time.Minute
+)
+
+var spoolNameReplacer = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+//remoteWriter 批量发送日志到远程服务，失败时重试，仍失败时写入本地缓存文件，服务恢复后补发
+type remoteWriter struct {
+	network     string
+	address     string
+	batchSize   int
+	interval    time.Duration
+	retry       int
+	spoolPath   string
+	spoolMax    int64
+	syncTimeout time.Duration
+
+	queue  chan []byte
+	flush  chan flushRequest
+	quit   chan struct{} //Close超时后通知后台goroutine退出
+	done   chan struct{} //后台goroutine退出后关闭
+	once   sync.Once
+	conn   net.Conn
+	client *http.Client
+
+	//补发失败后等待backoff再读取缓存文件，期间新日志直接追加到缓存，避免服务不可用时每批都读取整个缓存文件
+	spoolBackoff time.Duration
+	spoolRetryAt time.Time
+
+	dropped prometheus.Counter
+}
+
+//flushRequest 立即发送请求，ctx限制发送时间，stop为true时发送后退出
+type flushRequest struct {
+	ctx  context.Context
+	done chan error
+	stop bool
+}
+
+func newRemoteWriter(sink SinkOption) *remoteWriter {
+	w := &remoteWriter{
+		network:     strings.ToLower(sink.Type),
+		address:     sink.Address,
+		batchSize:   int(sink.BatchSize),
+		interval:    time.Duration(sink.BatchInterval) * time.Millisecond,
+		retry:       int(sink.RetryTimes),
+		spoolMax:    int64(sink.SpoolMaxSize) * 1024 * 1024,
+		syncTimeout: remoteSyncTimeout,
+		queue:       make(chan []byte, remoteQueueSize),
+		flush:       make(chan flushRequest),
+		quit:        make(chan struct{}),
+		done:        make(chan struct{}),
+		client:      &http.Client{Timeout: remoteTimeout},
+		dropped:     droppedLogs.WithLabelValues("remote"),
+	}
+	if w.batchSize <= 0 {
+		w.batchSize = defaultBatchSize
+	}
+	if w.interval <= 0 {
+		w.interval = defaultBatchInterval * time.Millisecond
+	}
+	if w.retry <= 0 {
+		w.retry = defaultRetryTimes
+	}
+	if w.spoolMax <= 0 {
+		w.spoolMax = defaultSpoolMaxSize * 1024 * 1024
+	}
+	if sink.SpoolDir != "" {
+		name := spoolNameReplacer.ReplaceAllString(w.address, "_")
+		w.spoolPath = filepath.Join(sink.SpoolDir, name+".spool")
+	}
+
+	go w.run()
+	return w
+}
+
+func (w *remoteWriter) Write(p []byte) (int, error) {
+	b := make([]byte, len(p))
+	copy(b, p)
+
+	select {
+	case w.queue <- b:
+	default:
+		w.dropped.Inc()
+	}
+	return len(p), nil
+}
+
+//Sync 立即发送队列中的日志，最多等待syncTimeout，远程服务不可用时不会阻塞退出流程
+func (w *remoteWriter) Sync() error {
+	return w.request(false)
+}
+
+//Close 发送队列中的日志后停止后台goroutine，可重复调用
+func (w *remoteWriter) Close() error {
+	var err error
+	w.once.Do(func() {
+		err = w.request(true)
+		if err != nil {
+			close(w.quit)
+		}
+	})
+	return err
+}
+
+func (w *remoteWriter) request(stop bool) error {
+	return requestFlush(w.flush, w.done, w.syncTimeout, stop, w.address)
+}
+
+//requestFlush 请求后台goroutine立即发送并等待结果，最多等待timeout，后台goroutine已退出时直接返回
+func requestFlush(flush chan<- flushRequest, done <-chan struct{}, timeout time.Duration, stop bool, address string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req := flushRequest{ctx: ctx, done: make(chan error, 1), stop: stop}
+	select {
+	case flush <- req:
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("sync log %s: %v", address, ctx.Err())
+	}
+
+	select {
+	case err := <-req.done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("sync log %s: %v", address, ctx.Err())
+	}
+}
+
+func (w *remoteWriter) run() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	defer close(w.done)
+	defer w.closeConn()
+
+	batch := make([][]byte, 0, w.batchSize)
+	for {
+		select {
+		case b := <-w.queue:
+			batch = append(batch, b)
+			if len(batch) >= w.batchSize {
+				w.sendBatch(context.Background(), batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				w.sendBatch(context.Background(), batch)
+				batch = batch[:0]
+			}
+		case req := <-w.flush:
+			//主动Sync/Close时立即补发
+			w.spoolRetryAt = time.Time{}
+			for len(w.queue) > 0 {
+				batch = append(batch, <-w.queue)
+			}
+			var err error
+			if len(batch) > 0 {
+				err = w.sendBatch(req.ctx, batch)
+				batch = batch[:0]
+			}
+			req.done <- err
+			if req.stop {
+				return
+			}
+		case <-w.quit:
+			return
+		}
+	}
+}
+
+//sendBatch 先补发本地缓存，保证日志顺序，超时或发送失败时写入本地缓存
+func (w *remoteWriter) sendBatch(ctx context.Context, batch [][]byte) error {
+	data := bytes.Join(batch, nil)
+
+	if err := w.resendSpool(ctx); err != nil {
+		return w.spool(data, len(batch))
+	}
+	if err := w.sendWithRetry(ctx, data); err != nil {
+		return w.spool(data, len(batch))
+	}
+	return nil
+}
+
+func (w *remoteWriter) sendWithRetry(ctx context.Context, data []byte) error {
+	var err error
+	for i := 0; i <= w.retry; i++ {
+		if err = w.send(ctx, data); err == nil {
+			return nil
+		}
+		if i < w.retry {
+			select {
+			case <-time.After(time.Duration(i+1) * 100 * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	return err
+}
+
+func (w *remoteWriter) send(ctx context.Context, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if w.network == SINK_HTTP {
+		req, err := http.NewRequest(http.MethodPost, w.address, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		rsp, err := w.client.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		defer rsp.Body.Close()
+		io.Copy(ioutil.Discard, rsp.Body)
+		if rsp.StatusCode >= 300 {
+			return fmt.Errorf("remote log server %s response status %s", w.address, rsp.Status)
+		}
+		return nil
+	}
+
+	if w.conn == nil {
+		dialer := net.Dialer{Timeout: remoteTimeout}
+		conn, err := dialer.DialContext(ctx, "tcp", w.address)
+		if err != nil {
+			return err
+		}
+		w.conn = conn
+	}
+	deadline := time.Now().Add(remoteTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	w.conn.SetWriteDeadline(deadline)
+	if _, err := w.conn.Write(data); err != nil {
+		w.closeConn()
+		return err
+	}
+	return nil
+}
+
+func (w *remoteWriter) closeConn() {
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+}
+
+//spool 写入本地缓存文件，未配置缓存目录或超过大小上限时丢弃
+func (w *remoteWriter) spool(data []byte, count int) error {
+	if w.spoolPath == "" {
+		w.dropped.Add(float64(count))
+		return fmt.Errorf("remote log server %s unavailable, %d entries dropped", w.address, count)
+	}
+
+	if fi, err := os.Stat(w.spoolPath); err == nil && fi.Size()+int64(len(data)) > w.spoolMax {
+		w.dropped.Add(float64(count))
+		return fmt.Errorf("log spool %s is full, %d entries dropped", w.spoolPath, count)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(w.spoolPath), 0755); err != nil {
+		w.dropped.Add(float64(count))
+		return err
+	}
+	f, err := os.OpenFile(w.spoolPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		w.dropped.Add(float64(count))
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(data)
+	return err
+}
+
+//resendSpool 按batchSize条分批补发本地缓存的日志，每批发送成功后从缓存文件中删除，全部发送后删除缓存文件；
+//补发失败后spoolBackoff内不再补发，返回错误使新日志追加到缓存，保证日志顺序
+func (w *remoteWriter) resendSpool(ctx context.Context) error {
+	if w.spoolPath == "" {
+		return nil
+	}
+	if now := time.Now(); now.Before(w.spoolRetryAt) {
+		return fmt.Errorf("resend log spool %s after %v", w.spoolPath, w.spoolRetryAt.Sub(now))
+	}
+
+	data, err := ioutil.ReadFile(w.spoolPath)
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+	for len(data) > 0 {
+		chunk := spoolChunk(data, w.batchSize)
+		if err := w.send(ctx, chunk); err != nil {
+			w.backoffSpool()
+			return err
+		}
+		data = data[len(chunk):]
+		if err := w.truncateSpool(data); err != nil {
+			return err
+		}
+	}
+	w.spoolBackoff = 0
+	return nil
+}
+
+//backoffSpool 补发失败，延长下次补发的等待时间
+func (w *remoteWriter) backoffSpool() {
+	w.spoolBackoff *= 2
+	if w.spoolBackoff < spoolRetryBackoff {
+		w.spoolBackoff = spoolRetryBackoff
+	} else if w.spoolBackoff > spoolMaxRetryBackoff {
+		w.spoolBackoff = spoolMaxRetryBackoff
+	}
+	w.spoolRetryAt = time.Now().Add(w.spoolBackoff)
+}
+
+//spoolChunk 返回缓存数据的前n行
+func spoolChunk(data []byte, n int) []byte {
+	end := 0
+	for i := 0; i < n && end < len(data); i++ {
+		idx := bytes.IndexByte(data[end:], '\n')
+		if idx < 0 {
+			return data
+		}
+		end += idx + 1
+	}
+	return data[:end]
+}
+
+//truncateSpool 缓存文件只保留未发送的日志，为空时删除缓存文件
+func (w *remoteWriter) truncateSpool(rest []byte) error {
+	if len(rest) == 0 {
+		return os.Remove(w.spoolPath)
+	}
+	tmp := w.spoolPath + ".tmp"
+	if err := ioutil.WriteFile(tmp, rest, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, w.spoolPath)
+}
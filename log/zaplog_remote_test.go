@@ -0,0 +1,198 @@
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+//logCollector 本地http日志服务，记录每次请求的日志行数，failAfter次请求后返回500
+type logCollector struct {
+	mu        sync.Mutex
+	requests  int
+	batches   []int
+	lines     [][]byte
+	failAfter int
+}
+
+func (c *logCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests++
+	if c.failAfter >= 0 && len(c.batches) >= c.failAfter {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	lines := bytes.SplitAfter(body, []byte("\n"))
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	c.batches = append(c.batches, len(lines))
+	c.lines = append(c.lines, lines...)
+}
+
+func (c *logCollector) received() ([]int, [][]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]int(nil), c.batches...), append([][]byte(nil), c.lines...)
+}
+
+func TestRemoteWriterResendSpool(t *testing.T) {
+	collector := &logCollector{failAfter: 2}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	dir := t.TempDir()
+	w := newRemoteWriter(SinkOption{Type: SINK_HTTP, Address: server.URL, BatchSize: 100, BatchInterval: 60000, SpoolDir: dir})
+	defer w.Close()
+
+	var spooled bytes.Buffer
+	for i := 0; i < 250; i++ {
+		fmt.Fprintf(&spooled, "{\"n\":%d}\n", i)
+	}
+	if err := ioutil.WriteFile(w.spoolPath, spooled.Bytes(), 0644); err != nil {
+		t.Fatalf("write spool: %v", err)
+	}
+
+	//发送新日志前先补发缓存，前两批发送成功后服务出错，已发送的日志从缓存中删除，新日志追加到缓存
+	w.Write([]byte("{\"n\":250}\n"))
+	if err := w.Sync(); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	batches, _ := collector.received()
+	if len(batches) != 2 || batches[0] != 100 || batches[1] != 100 {
+		t.Fatalf("batches = %v, want [100 100]", batches)
+	}
+	rest, err := ioutil.ReadFile(w.spoolPath)
+	if err != nil {
+		t.Fatalf("read spool: %v", err)
+	}
+	if n := bytes.Count(rest, []byte("\n")); n != 51 || !bytes.HasPrefix(rest, []byte("{\"n\":200}\n")) {
+		t.Fatalf("spool has %d lines starting with %q, want 51 from n=200", n, bytes.SplitN(rest, []byte("\n"), 2)[0])
+	}
+
+	//服务恢复后补发剩余日志，每条只发送一次
+	collector.mu.Lock()
+	collector.failAfter = -1
+	collector.mu.Unlock()
+	w.Write([]byte("{\"n\":251}\n"))
+	if err := w.Sync(); err != nil {
+		t.Fatalf("sync after recovery: %v", err)
+	}
+	_, lines := collector.received()
+	if len(lines) != 252 {
+		t.Fatalf("received %d lines, want 252", len(lines))
+	}
+	for i, line := range lines {
+		if want := fmt.Sprintf("{\"n\":%d}\n", i); string(line) != want {
+			t.Fatalf("line %d = %q, want %q", i, line, want)
+		}
+	}
+	if _, err := os.Stat(w.spoolPath); !os.IsNotExist(err) {
+		t.Fatalf("spool not removed: %v", err)
+	}
+}
+
+func TestRemoteWriterSyncTimeout(t *testing.T) {
+	//tcp服务接受连接但不读取，写满缓冲区后发送阻塞
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	var conns []net.Conn
+	var mu sync.Mutex
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+		}
+	}()
+	defer func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	dir := t.TempDir()
+	w := newRemoteWriter(SinkOption{Type: SINK_TCP, Address: ln.Addr().String(), BatchSize: 100000, BatchInterval: 60000, SpoolDir: dir})
+	w.syncTimeout = 200 * time.Millisecond
+
+	line := append(bytes.Repeat([]byte("x"), 1024), '\n')
+	for i := 0; i < 4096; i++ {
+		w.Write(line)
+	}
+
+	start := time.Now()
+	w.Sync()
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("sync took %v with blocked endpoint", elapsed)
+	}
+
+	start = time.Now()
+	w.Close()
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("close took %v with blocked endpoint", elapsed)
+	}
+	select {
+	case <-w.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("remote writer goroutine not stopped")
+	}
+
+	//超时未发送的日志写入本地缓存
+	if _, err := os.Stat(w.spoolPath); err != nil {
+		t.Fatalf("spool not written: %v", err)
+	}
+}
+
+func TestRemoteWriterSpoolBackoff(t *testing.T) {
+	collector := &logCollector{failAfter: 0}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	dir := t.TempDir()
+	w := newRemoteWriter(SinkOption{Type: SINK_HTTP, Address: server.URL, BatchSize: 10, BatchInterval: 60000, SpoolDir: dir})
+	defer w.Close()
+	if err := ioutil.WriteFile(w.spoolPath, []byte("{\"n\":0}\n"), 0644); err != nil {
+		t.Fatalf("write spool: %v", err)
+	}
+
+	//补发失败后等待期间新日志直接追加到缓存，不再读取缓存文件及请求服务
+	for i := 1; i <= 50; i++ {
+		fmt.Fprintf(w, "{\"n\":%d}\n", i)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, _ := ioutil.ReadFile(w.spoolPath)
+		if bytes.Count(data, []byte("\n")) == 51 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("spool has %d lines, want 51", bytes.Count(data, []byte("\n")))
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	collector.mu.Lock()
+	requests := collector.requests
+	collector.mu.Unlock()
+	if requests != 1 {
+		t.Fatalf("collector requests = %d, want 1", requests)
+	}
+}
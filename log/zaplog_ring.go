@@ -0,0 +1,317 @@
+package log
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	defaultRingSize  = 1000
+	ringTailBuffer   = 256
+	defaultRingLimit = 1000
+)
+
+//RingOption 内存日志缓存配置，每个级别保留最近Size条日志，可通过admin端口查询
+type RingOption struct {
+	Size int32 //每个级别保留的日志条数，缺省1000
+}
+
+//RingEntry 内存中缓存的日志
+type RingEntry struct {
+	Time    time.Time              `json:"time"`
+	Level   string                 `json:"level"`
+	Logger  string                 `json:"logger,omitempty"`
+	Message string                 `json:"message"`
+	Caller  string                 `json:"caller,omitempty"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+
+	level zapcore.Level
+}
+
+//RingFilter 日志查询条件
+type RingFilter struct {
+	Level   zapcore.Level //最低日志级别
+	Logger  string        //模块名，包含子模块
+	TraceID string        //trace id
+	Since   time.Time     //起始时间
+	Until   time.Time     //结束时间
+	Limit   int           //最多返回条数，返回最新的日志
+}
+
+var logRing atomic.Value //*ringBuffer，重新创建日志时替换
+
+//loadRing 当前日志的内存缓存，未开启Ring时为nil
+func loadRing() *ringBuffer {
+	ring, _ := logRing.Load().(*ringBuffer)
+	return ring
+}
+
+//RingEnabled 当前日志是否开启了内存缓存
+func RingEnabled() bool {
+	return loadRing() != nil
+}
+
+//ringBuffer 按级别分别保存最近的日志，并支持实时订阅
+type ringBuffer struct {
+	mu      sync.RWMutex
+	size    int
+	entries map[zapcore.Level][]*RingEntry
+	next    map[zapcore.Level]int
+	subs    map[chan *RingEntry]struct{}
+}
+
+func newRingBuffer(opt *RingOption) *ringBuffer {
+	size := int(opt.Size)
+	if size <= 0 {
+		size = defaultRingSize
+	}
+	return &ringBuffer{
+		size:    size,
+		entries: make(map[zapcore.Level][]*RingEntry),
+		next:    make(map[zapcore.Level]int),
+		subs:    make(map[chan *RingEntry]struct{}),
+	}
+}
+
+func (r *ringBuffer) add(e *RingEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entries := r.entries[e.level]
+	if len(entries) < r.size {
+		r.entries[e.level] = append(entries, e)
+	} else {
+		entries[r.next[e.level]] = e
+		r.next[e.level] = (r.next[e.level] + 1) % r.size
+	}
+
+	//订阅方处理过慢时丢弃，不阻塞写日志
+	for ch := range r.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+//query 按条件查询，结果按时间排序
+func (r *ringBuffer) query(f *RingFilter) []*RingEntry {
+	r.mu.RLock()
+	result := make([]*RingEntry, 0)
+	for _, entries := range r.entries {
+		for _, e := range entries {
+			if f.match(e) {
+				result = append(result, e)
+			}
+		}
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Time.Before(result[j].Time)
+	})
+	if f.Limit > 0 && len(result) > f.Limit {
+		result = result[len(result)-f.Limit:]
+	}
+	return result
+}
+
+func (r *ringBuffer) subscribe() chan *RingEntry {
+	ch := make(chan *RingEntry, ringTailBuffer)
+	r.mu.Lock()
+	r.subs[ch] = struct{}{}
+	r.mu.Unlock()
+	return ch
+}
+
+func (r *ringBuffer) unsubscribe(ch chan *RingEntry) {
+	r.mu.Lock()
+	delete(r.subs, ch)
+	r.mu.Unlock()
+}
+
+func (f *RingFilter) match(e *RingEntry) bool {
+	if e.level < f.Level {
+		return false
+	}
+	if f.Logger != "" && e.Logger != f.Logger && !strings.HasPrefix(e.Logger, f.Logger+".") {
+		return false
+	}
+	if f.TraceID != "" {
+		if id, _ := e.Fields["trace_id"].(string); id != f.TraceID {
+			return false
+		}
+	}
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && e.Time.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+//ringCore 将日志写入内存缓存的日志模块
+type ringCore struct {
+	ring   *ringBuffer
+	fields []zapcore.Field
+}
+
+func (c *ringCore) Enabled(zapcore.Level) bool {
+	return true
+}
+
+func (c *ringCore) With(fields []zapcore.Field) zapcore.Core {
+	all := make([]zapcore.Field, 0, len(c.fields)+len(fields))
+	all = append(all, c.fields...)
+	all = append(all, fields...)
+	return &ringCore{ring: c.ring, fields: all}
+}
+
+func (c *ringCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return ce.AddCore(ent, c)
+}
+
+func (c *ringCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range c.fields {
+		f.AddTo(enc)
+	}
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+
+	e := &RingEntry{
+		Time:    ent.Time,
+		Level:   ent.Level.String(),
+		Logger:  ent.LoggerName,
+		Message: ent.Message,
+		Fields:  enc.Fields,
+		level:   ent.Level,
+	}
+	if ent.Caller.Defined {
+		e.Caller = ent.Caller.TrimmedPath()
+	}
+	c.ring.add(e)
+	return nil
+}
+
+func (c *ringCore) Sync() error {
+	return nil
+}
+
+//parseRingFilter 解析查询参数: level,logger,trace_id,since,until(RFC3339),limit
+func parseRingFilter(r *http.Request) (*RingFilter, error) {
+	q := r.URL.Query()
+	f := &RingFilter{Level: zapcore.DebugLevel, Limit: defaultRingLimit}
+
+	if v := q.Get("level"); v != "" {
+		if err := f.Level.Set(strings.ToLower(v)); err != nil {
+			return nil, err
+		}
+	}
+	f.Logger = strings.ToLower(q.Get("logger"))
+	f.TraceID = q.Get("trace_id")
+
+	var err error
+	if v := q.Get("since"); v != "" {
+		if f.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, err
+		}
+	}
+	if v := q.Get("until"); v != "" {
+		if f.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, err
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+//QueryRing 查询内存中缓存的日志，未开启Ring时返回空
+func QueryRing(f *RingFilter) []*RingEntry {
+	ring := loadRing()
+	if ring == nil {
+		return nil
+	}
+	return ring.query(f)
+}
+
+//RingHandler 查询内存日志的http接口，返回json数组
+//  /debug/logs?level=warn&logger=pool&trace_id=xxx&since=2019-07-30T00:00:00Z&limit=100
+func RingHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ring := loadRing()
+		if ring == nil {
+			http.Error(w, "log ring buffer is not enabled", http.StatusServiceUnavailable)
+			return
+		}
+
+		f, err := parseRingFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ring.query(f))
+	})
+}
+
+//RingTailHandler 实时输出新日志的http接口，按行输出json，直到客户端断开
+//  /debug/logs/tail?level=error&logger=grpc
+func RingTailHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ring := loadRing()
+		if ring == nil {
+			http.Error(w, "log ring buffer is not enabled", http.StatusServiceUnavailable)
+			return
+		}
+
+		f, err := parseRingFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+
+		ch := ring.subscribe()
+		defer ring.unsubscribe(ch)
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		enc := json.NewEncoder(w)
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case e := <-ch:
+				if !f.match(e) {
+					continue
+				}
+				if err := enc.Encode(e); err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	})
+}
@@ -0,0 +1,207 @@
+package log
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+//TestRingReload 重新创建日志与查询内存日志并发进行，需配合-race运行
+func TestRingReload(t *testing.T) {
+	t.Cleanup(func() {
+		logRing.Store((*ringBuffer)(nil))
+		Close()
+	})
+
+	opt := &LoggerOption{Level: "info", FilePath: filepath.Join(t.TempDir(), "ring.log"), Ring: &RingOption{Size: 10}}
+	NewLogger(opt).Info("ring")
+	if !RingEnabled() {
+		t.Fatalf("ring not enabled")
+	}
+	if entries := QueryRing(&RingFilter{}); len(entries) != 1 {
+		t.Fatalf("ring entries = %d, want 1", len(entries))
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			NewLogger(opt)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			QueryRing(&RingFilter{})
+			RingEnabled()
+		}
+	}()
+	wg.Wait()
+
+	noRing := *opt
+	noRing.Ring = nil
+	NewLogger(&noRing)
+	if RingEnabled() {
+		t.Fatalf("ring enabled after rebuild without Ring")
+	}
+}
+
+var ringTestTime = time.Date(2019, 7, 30, 0, 0, 0, 0, time.UTC)
+
+//newTestRing 创建内存日志缓存并设置为当前缓存，测试结束时恢复
+func newTestRing(t *testing.T) *ringBuffer {
+	ring := newRingBuffer(&RingOption{Size: 10})
+	logRing.Store(ring)
+	t.Cleanup(func() {
+		logRing.Store((*ringBuffer)(nil))
+	})
+	return ring
+}
+
+func addRingEntry(ring *ringBuffer, level zapcore.Level, logger string, traceID string, minutes int, msg string) {
+	e := &RingEntry{
+		Time:    ringTestTime.Add(time.Duration(minutes) * time.Minute),
+		Level:   level.String(),
+		Logger:  logger,
+		Message: msg,
+		level:   level,
+	}
+	if traceID != "" {
+		e.Fields = map[string]interface{}{"trace_id": traceID}
+	}
+	ring.add(e)
+}
+
+func ringMessages(entries []*RingEntry) string {
+	msgs := make([]string, 0, len(entries))
+	for _, e := range entries {
+		msgs = append(msgs, e.Message)
+	}
+	return strings.Join(msgs, ",")
+}
+
+func TestRingHandler(t *testing.T) {
+	handler := RingHandler()
+	query := func(rawQuery string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/logs?"+rawQuery, nil))
+		return rec
+	}
+
+	if rec := query(""); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status without ring = %d, want 503", rec.Code)
+	}
+
+	ring := newTestRing(t)
+	addRingEntry(ring, zapcore.InfoLevel, "pool", "t1", 0, "e1")
+	addRingEntry(ring, zapcore.WarnLevel, "pool.conn", "t2", 1, "e2")
+	addRingEntry(ring, zapcore.ErrorLevel, "grpc", "t1", 2, "e3")
+	addRingEntry(ring, zapcore.WarnLevel, "poolx", "", 3, "e4")
+
+	for _, tc := range []struct {
+		query string
+		want  string
+	}{
+		{"", "e1,e2,e3,e4"},
+		{"level=warn", "e2,e3,e4"},
+		{"level=ERROR", "e3"},
+		{"logger=pool", "e1,e2"},
+		{"logger=pool.conn", "e2"},
+		{"trace_id=t1", "e1,e3"},
+		{"since=2019-07-30T00:01:00Z&until=2019-07-30T00:02:00Z", "e2,e3"},
+		{"limit=2", "e3,e4"},
+		{"level=warn&logger=pool&limit=1", "e2"},
+	} {
+		rec := query(tc.query)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, body = %s", tc.query, rec.Code, rec.Body)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Fatalf("%s: content type = %s", tc.query, ct)
+		}
+		var entries []*RingEntry
+		if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+			t.Fatalf("%s: decode: %v", tc.query, err)
+		}
+		if got := ringMessages(entries); got != tc.want {
+			t.Fatalf("%s: entries = %s, want %s", tc.query, got, tc.want)
+		}
+	}
+
+	for _, bad := range []string{"level=verbose", "since=yesterday", "until=2019-07-30", "limit=ten"} {
+		if rec := query(bad); rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status = %d, want 400", bad, rec.Code)
+		}
+	}
+}
+
+func TestRingTailHandler(t *testing.T) {
+	server := httptest.NewServer(RingTailHandler())
+	defer server.Close()
+
+	rsp, err := http.Get(server.URL + "?level=error")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("status without ring = %d, want 503", rsp.StatusCode)
+	}
+
+	ring := newTestRing(t)
+	rsp, err = http.Get(server.URL + "?level=bad")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("status with bad level = %d, want 400", rsp.StatusCode)
+	}
+
+	rsp, err = http.Get(server.URL + "?level=error&logger=grpc")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK || rsp.Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("status = %d, content type = %s", rsp.StatusCode, rsp.Header.Get("Content-Type"))
+	}
+
+	//响应头返回后已订阅，只输出之后符合条件的日志
+	addRingEntry(ring, zapcore.InfoLevel, "grpc", "", 0, "info")
+	addRingEntry(ring, zapcore.ErrorLevel, "pool", "", 1, "other logger")
+	addRingEntry(ring, zapcore.ErrorLevel, "grpc.server", "", 2, "match1")
+	addRingEntry(ring, zapcore.FatalLevel, "grpc", "", 3, "match2")
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(rsp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	for _, want := range []string{"match1", "match2"} {
+		select {
+		case line := <-lines:
+			var e RingEntry
+			if err := json.Unmarshal([]byte(line), &e); err != nil {
+				t.Fatalf("decode %q: %v", line, err)
+			}
+			if e.Message != want {
+				t.Fatalf("tail entry = %s, want %s", e.Message, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("tail entry %s not received", want)
+		}
+	}
+}
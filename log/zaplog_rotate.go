@@ -0,0 +1,190 @@
+package log
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ROTATE_SIZE = "size" //按大小滚动(lumberjack)
+	ROTATE_TIME = "time" //按时间滚动
+
+	ROTATE_HOURLY = "hour" //每小时滚动
+	ROTATE_DAILY  = "day"  //每天滚动
+
+	hourlyLayout = "2006010215"
+	dailyLayout  = "20060102"
+)
+
+//timeRotateWriter 按时间滚动的日志文件，文件名带日期，如app-20190730.log
+//滚动后按保存天数与总大小清理旧文件，并回调hook(如转移文件用于日志采集)
+type timeRotateWriter struct {
+	mu        sync.Mutex
+	filename  string
+	interval  string
+	maxAge    time.Duration
+	maxTotal  int64
+	hook      func(filename string)
+	file      *os.File
+	periodEnd time.Time
+}
+
+//newTimeRotateWriter 创建按时间滚动的日志文件
+//maxAge 文件最多保存多少天，maxTotal 所有日志文件总大小上限 单位：M，为0时不限制
+func newTimeRotateWriter(filename string, interval string, maxAge int, maxTotal int, hook func(string)) *timeRotateWriter {
+	w := &timeRotateWriter{
+		filename: filename,
+		interval: strings.ToLower(interval),
+		maxAge:   time.Duration(maxAge) * 24 * time.Hour,
+		maxTotal: int64(maxTotal) * 1024 * 1024,
+		hook:     hook,
+	}
+	if w.interval != ROTATE_HOURLY {
+		w.interval = ROTATE_DAILY
+	}
+	return w
+}
+
+func (w *timeRotateWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now()
+	if w.file == nil || !now.Before(w.periodEnd) {
+		if err := w.rotate(now); err != nil {
+			return 0, err
+		}
+	}
+	return w.file.Write(p)
+}
+
+func (w *timeRotateWriter) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	return w.file.Sync()
+}
+
+func (w *timeRotateWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+//layout 文件名中的时间格式
+func (w *timeRotateWriter) layout() string {
+	if w.interval == ROTATE_HOURLY {
+		return hourlyLayout
+	}
+	return dailyLayout
+}
+
+//period 当前时间所在的滚动周期
+func (w *timeRotateWriter) period(now time.Time) (time.Time, time.Time) {
+	if w.interval == ROTATE_HOURLY {
+		start := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 0, 0, now.Location())
+		return start, start.Add(time.Hour)
+	}
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	return start, start.AddDate(0, 0, 1)
+}
+
+//stampedName app.log -> app-20190730.log
+func (w *timeRotateWriter) stampedName(t time.Time) string {
+	ext := filepath.Ext(w.filename)
+	prefix := strings.TrimSuffix(w.filename, ext)
+	return prefix + "-" + t.Format(w.layout()) + ext
+}
+
+func (w *timeRotateWriter) rotate(now time.Time) error {
+	start, end := w.period(now)
+	name := w.stampedName(start)
+
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	prev := ""
+	if w.file != nil {
+		prev = w.file.Name()
+		w.file.Close()
+	}
+	w.file = file
+	w.periodEnd = end
+
+	if prev != "" && prev != name && w.hook != nil {
+		go w.hook(prev)
+	}
+	w.cleanup(name, now)
+	return nil
+}
+
+type rotatedFile struct {
+	name string
+	t    time.Time
+	size int64
+}
+
+//cleanup 删除超过保存天数的文件，以及超出总大小上限的最旧文件
+func (w *timeRotateWriter) cleanup(current string, now time.Time) {
+	if w.maxAge <= 0 && w.maxTotal <= 0 {
+		return
+	}
+
+	ext := filepath.Ext(w.filename)
+	prefix := strings.TrimSuffix(w.filename, ext) + "-"
+	names, err := filepath.Glob(prefix + "*" + ext)
+	if err != nil {
+		return
+	}
+
+	files := make([]rotatedFile, 0, len(names))
+	var total int64
+	for _, name := range names {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext)
+		t, err := time.ParseInLocation(w.layout(), stamp, now.Location())
+		if err != nil {
+			continue
+		}
+		fi, err := os.Stat(name)
+		if err != nil {
+			continue
+		}
+		total += fi.Size()
+		if name != current {
+			files = append(files, rotatedFile{name: name, t: t, size: fi.Size()})
+		}
+	}
+
+	//从最旧的文件开始删除
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].t.Before(files[j].t)
+	})
+	for _, f := range files {
+		expired := w.maxAge > 0 && f.t.Before(now.Add(-w.maxAge))
+		oversize := w.maxTotal > 0 && total > w.maxTotal
+		if !expired && !oversize {
+			continue
+		}
+		if err := os.Remove(f.name); err == nil {
+			total -= f.size
+		}
+	}
+}
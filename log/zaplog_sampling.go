@@ -0,0 +1,101 @@
+package log
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap/zapcore"
+)
+
+//SamplingOption 日志采样配置，用于高QPS下限制重复日志
+type SamplingOption struct {
+	Tick       int32 //采样周期 单位：毫秒，缺省1000
+	Initial    int32 //每个周期内相同级别与内容的日志前Initial条全部输出
+	Thereafter int32 //超过Initial后每Thereafter条输出一条，为0时全部丢弃
+}
+
+//CallLogRule grpc调用日志规则
+type CallLogRule struct {
+	Method        string //方法全名，如/grpc.health.v1.Health/Check，以*结尾时按前缀匹配，为空匹配所有方法
+	Skip          bool   //不记录调用日志
+	ErrorOnly     bool   //只记录出错的调用
+	SlowThreshold int32  //只记录出错或耗时超过该阈值的调用 单位：毫秒，0为不限制
+}
+
+var (
+	callLogRules atomic.Value //[]CallLogRule，配置重新加载时整体替换
+
+	droppedLogs = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "log_dropped_entries_total",
+			Help: "Total number of log entries dropped by sampling or call log rules.",
+		}, []string{"reason"})
+)
+
+func init() {
+	prometheus.MustRegister(droppedLogs)
+}
+
+//newSampler 按配置对日志采样，丢弃的日志计入log_dropped_entries_total
+func newSampler(core zapcore.Core, opt *SamplingOption) zapcore.Core {
+	if opt == nil {
+		return core
+	}
+
+	tick := time.Duration(opt.Tick) * time.Millisecond
+	if tick <= 0 {
+		tick = time.Second
+	}
+
+	dropped := droppedLogs.WithLabelValues("sampling")
+	hook := zapcore.SamplerHook(func(ent zapcore.Entry, dec zapcore.SamplingDecision) {
+		if dec&zapcore.LogDropped != 0 {
+			dropped.Inc()
+		}
+	})
+	return zapcore.NewSamplerWithOptions(core, tick, int(opt.Initial), int(opt.Thereafter), hook)
+}
+
+//setCallLogRules 更新调用日志规则，可在拦截器读取时并发调用
+func setCallLogRules(rules []CallLogRule) {
+	callLogRules.Store(append([]CallLogRule(nil), rules...))
+}
+
+//match 规则是否匹配该方法
+func (r *CallLogRule) match(method string) bool {
+	if r.Method == "" || r.Method == "*" {
+		return true
+	}
+	if strings.HasSuffix(r.Method, "*") {
+		return strings.HasPrefix(method, strings.TrimSuffix(r.Method, "*"))
+	}
+	return r.Method == method
+}
+
+//ShouldLogCall 按CallLogRules判断grpc调用是否记录日志，按顺序使用第一条匹配的规则，没有匹配时记录
+func ShouldLogCall(method string, err error, elapsed time.Duration) bool {
+	rules, _ := callLogRules.Load().([]CallLogRule)
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.match(method) {
+			continue
+		}
+
+		ok := true
+		if rule.Skip {
+			ok = false
+		} else if err == nil && rule.ErrorOnly {
+			ok = false
+		} else if err == nil && rule.SlowThreshold > 0 {
+			ok = elapsed >= time.Duration(rule.SlowThreshold)*time.Millisecond
+		}
+
+		if !ok {
+			droppedLogs.WithLabelValues("call_rule").Inc()
+		}
+		return ok
+	}
+	return true
+}
@@ -0,0 +1,183 @@
+package log
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	"github.com/natefinch/lumberjack"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	SINK_CONSOLE = "console" //输出到控制台(stdout)
+	SINK_FILE    = "file"    //输出到文件，按大小或时间滚动
+
+	ENCODER_JSON    = "json"    //json格式
+	ENCODER_CONSOLE = "console" //便于阅读的文本格式
+)
+
+//SinkOption 单个日志输出配置
+type SinkOption struct {
+	Type       string //输出类型: console,file,syslog,tcp,http
+	Level      string //该输出的最低日志级别，为空时与全局Level一致
+	Encoder    string //编码格式: json,console，缺省为json
+	Color      bool   //console编码时日志级别是否彩色显示
+	FilePath   string //file类型的日志文件路径
+	MaxSize    int32  //每个日志文件保存的最大尺寸 单位：M，为0时使用全局配置
+	MaxBackups int32  //日志文件最多保存多少个备份，为0时使用全局配置
+	MaxAge     int32  //文件最多保存多少天，为0时使用全局配置
+	Compress   bool   //是否压缩
+
+	Rotate         string //滚动方式: size(按大小),time(按时间)，为空时使用全局配置
+	RotateInterval string //按时间滚动的周期: hour,day，为空时使用全局配置
+	MaxTotalSize   int32  //按时间滚动时所有日志文件总大小上限 单位：M，为0时使用全局配置
+
+	Async *AsyncOption //异步写入，为空时使用全局配置
+
+	Network       string //syslog网络类型: udp,tcp,unix，缺省udp
+	Address       string //syslog或远程日志服务地址，http类型为完整url
+	Facility      string //syslog facility，如user,local0，缺省user
+	Tag           string //syslog APP-NAME，缺省为进程名
+	BatchSize     int32  //tcp/http每批发送的日志条数，缺省100
+	BatchInterval int32  //tcp/http发送间隔 单位：毫秒，缺省1000
+	RetryTimes    int32  //tcp/http发送失败重试次数，缺省3
+	SpoolDir      string //tcp/http服务不可用时日志的本地缓存目录，为空时丢弃
+	SpoolMaxSize  int32  //本地缓存文件大小上限 单位：M，缺省32
+}
+
+//defaultSinks 未配置Sinks时按Console/FilePath生成单个输出
+func defaultSinks(opt *LoggerOption) []SinkOption {
+	if opt.Console {
+		return []SinkOption{{Type: SINK_CONSOLE}}
+	}
+	return []SinkOption{{Type: SINK_FILE, FilePath: opt.FilePath, Compress: opt.Compress}}
+}
+
+//newSinkCores 为每个输出构造日志模块，最终通过zapcore.NewTee合并，返回的closer用于关闭日志文件等输出
+func newSinkCores(opt *LoggerOption) ([]zapcore.Core, []io.Closer) {
+	sinks := opt.Sinks
+	if len(sinks) == 0 {
+		sinks = defaultSinks(opt)
+	}
+
+	cores := make([]zapcore.Core, 0, len(sinks))
+	closers := make([]io.Closer, 0, len(sinks))
+	for _, sink := range sinks {
+		core, closer := newSinkCore(opt, sink)
+		cores = append(cores, core)
+		if closer != nil {
+			closers = append(closers, closer)
+		}
+	}
+	return cores, closers
+}
+
+//newSinkCore 构造单个输出的日志模块，控制台等不需要关闭的输出closer为空
+func newSinkCore(opt *LoggerOption, sink SinkOption) (zapcore.Core, io.Closer) {
+	if strings.ToLower(sink.Type) == SINK_SYSLOG {
+		return newSyslogCore(sink)
+	}
+
+	ws, closer := newWriteSyncer(opt, sink)
+
+	//文件输出可配置为异步写入
+	async := sink.Async
+	if async == nil {
+		async = opt.Async
+	}
+	if async != nil && strings.ToLower(sink.Type) == SINK_FILE {
+		name := sink.FilePath
+		if name == "" {
+			name = opt.FilePath
+		}
+		aw := newAsyncWriter(ws, closer, name, async)
+		ws, closer = aw, aw
+	}
+	return zapcore.NewCore(newEncoder(sink), ws, newSinkLevel(sink)), closer
+}
+
+//newSinkLevel 输出的最低级别，全局及模块级别在外层levelCore中过滤
+func newSinkLevel(sink SinkOption) zapcore.LevelEnabler {
+	if sink.Level == "" {
+		return zapcore.DebugLevel
+	}
+
+	var level zapcore.Level
+	level.Set(strings.ToLower(sink.Level))
+	return level
+}
+
+func newEncoder(sink SinkOption) zapcore.Encoder {
+	encoderConfig := newEncoderConfig()
+	if strings.ToLower(sink.Encoder) == ENCODER_CONSOLE {
+		if sink.Color {
+			encoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
+		} else {
+			encoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+		}
+		return zapcore.NewConsoleEncoder(encoderConfig)
+	}
+	return zapcore.NewJSONEncoder(encoderConfig)
+}
+
+func newWriteSyncer(opt *LoggerOption, sink SinkOption) (zapcore.WriteSyncer, io.Closer) {
+	switch strings.ToLower(sink.Type) {
+	case SINK_TCP, SINK_HTTP:
+		w := newRemoteWriter(sink)
+		return w, w
+	case SINK_FILE:
+	default:
+		return zapcore.Lock(zapcore.AddSync(os.Stdout)), nil
+	}
+
+	if sink.Rotate == "" {
+		sink.Rotate = opt.Rotate
+	}
+	if strings.ToLower(sink.Rotate) == ROTATE_TIME {
+		w := newTimeRotateFile(opt, sink)
+		return zapcore.AddSync(w), w
+	}
+
+	hook := &lumberjack.Logger{
+		Filename:   sink.FilePath,        // 日志文件路径
+		MaxSize:    int(sink.MaxSize),    // 每个日志文件保存的最大尺寸 单位：M
+		MaxBackups: int(sink.MaxBackups), // 日志文件最多保存多少个备份
+		MaxAge:     int(sink.MaxAge),     // 文件最多保存多少天
+		Compress:   sink.Compress,        // 是否压缩
+	}
+	if hook.Filename == "" {
+		hook.Filename = opt.FilePath
+	}
+	if hook.MaxSize <= 0 {
+		hook.MaxSize = int(opt.MaxSize)
+	}
+	if hook.MaxBackups <= 0 {
+		hook.MaxBackups = int(opt.MaxBackups)
+	}
+	if hook.MaxAge <= 0 {
+		hook.MaxAge = int(opt.MaxAge)
+	}
+	return zapcore.AddSync(hook), hook
+}
+
+//newTimeRotateFile 按时间滚动的日志文件，未配置的项使用全局配置
+func newTimeRotateFile(opt *LoggerOption, sink SinkOption) *timeRotateWriter {
+	filename := sink.FilePath
+	if filename == "" {
+		filename = opt.FilePath
+	}
+	interval := sink.RotateInterval
+	if interval == "" {
+		interval = opt.RotateInterval
+	}
+	maxAge := sink.MaxAge
+	if maxAge <= 0 {
+		maxAge = opt.MaxAge
+	}
+	maxTotal := sink.MaxTotalSize
+	if maxTotal <= 0 {
+		maxTotal = opt.MaxTotalSize
+	}
+	return newTimeRotateWriter(filename, interval, int(maxAge), int(maxTotal), opt.RotateHook)
+}
@@ -0,0 +1,292 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	SINK_SYSLOG = "syslog" //输出到syslog(RFC 5424)
+
+	syslogTimeLayout    = "2006-01-02T15:04:05.000000Z07:00"
+	syslogTimeout       = 3 * time.Second
+	syslogQueueSize     = 8192
+	syslogRetryInterval = time.Second //连接失败后重新连接的间隔，期间的日志直接丢弃
+)
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+//syslogSeverity zap日志级别对应的syslog severity
+func syslogSeverity(l zapcore.Level) int {
+	switch l {
+	case zapcore.DebugLevel:
+		return 7
+	case zapcore.InfoLevel:
+		return 6
+	case zapcore.WarnLevel:
+		return 4
+	case zapcore.ErrorLevel:
+		return 3
+	case zapcore.FatalLevel:
+		return 1
+	default:
+		return 2
+	}
+}
+
+//syslogCore 输出到syslog的日志模块，severity由日志级别决定
+type syslogCore struct {
+	zapcore.LevelEnabler
+	enc zapcore.Encoder
+	w   *syslogWriter
+}
+
+//newSyslogCore 返回的syslogWriter在重新创建日志或Close时关闭
+func newSyslogCore(sink SinkOption) (zapcore.Core, *syslogWriter) {
+	w := newSyslogWriter(sink)
+	return &syslogCore{
+		LevelEnabler: newSinkLevel(sink),
+		enc:          newEncoder(sink),
+		w:            w,
+	}, w
+}
+
+func (c *syslogCore) With(fields []zapcore.Field) zapcore.Core {
+	enc := c.enc.Clone()
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	return &syslogCore{LevelEnabler: c.LevelEnabler, enc: enc, w: c.w}
+}
+
+func (c *syslogCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *syslogCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.enc.EncodeEntry(ent, fields)
+	if err != nil {
+		return err
+	}
+	defer buf.Free()
+	c.w.enqueue(ent.Level, ent.Time, buf.Bytes())
+	return nil
+}
+
+func (c *syslogCore) Sync() error {
+	return c.w.Sync()
+}
+
+//syslogWriter RFC 5424格式发送，支持udp、tcp(RFC 6587 octet counting)与unix socket，
+//日志放入队列由后台goroutine发送，syslog服务不可用时不阻塞调用方，队列满时丢弃
+type syslogWriter struct {
+	network  string
+	address  string
+	facility int
+	hostname string
+	tag      string
+	pid      int
+
+	mu      sync.RWMutex
+	closed  bool
+	queue   chan []byte
+	flush   chan flushRequest
+	quit    chan struct{} //Close超时后通知后台goroutine退出
+	done    chan struct{} //后台goroutine退出后关闭
+	once    sync.Once
+	conn    net.Conn
+	retryAt time.Time //连接失败后下次连接的时间
+
+	dropped prometheus.Counter
+}
+
+func newSyslogWriter(sink SinkOption) *syslogWriter {
+	w := &syslogWriter{
+		network:  strings.ToLower(sink.Network),
+		address:  sink.Address,
+		facility: 1,
+		tag:      sink.Tag,
+		pid:      os.Getpid(),
+		queue:    make(chan []byte, syslogQueueSize),
+		flush:    make(chan flushRequest),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+		dropped:  droppedLogs.WithLabelValues("syslog"),
+	}
+	if f, ok := syslogFacilities[strings.ToLower(sink.Facility)]; ok {
+		w.facility = f
+	}
+	if w.network == "" {
+		w.network = "udp"
+	}
+	if w.address == "" {
+		if w.network == "unix" {
+			w.address = "/dev/log"
+		} else {
+			w.address = "localhost:514"
+		}
+	}
+	if w.tag == "" {
+		w.tag = filepath.Base(os.Args[0])
+	}
+	w.hostname, _ = os.Hostname()
+	if w.hostname == "" {
+		w.hostname = "-"
+	}
+
+	go w.run()
+	return w
+}
+
+//enqueue 格式化后放入发送队列，队列满或已关闭时丢弃
+func (w *syslogWriter) enqueue(level zapcore.Level, t time.Time, msg []byte) {
+	data := w.format(level, t, msg)
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		w.dropped.Inc()
+		return
+	}
+	select {
+	case w.queue <- data:
+	default:
+		w.dropped.Inc()
+	}
+}
+
+//Sync 发送队列中的日志，最多等待syslogTimeout
+func (w *syslogWriter) Sync() error {
+	return requestFlush(w.flush, w.done, syslogTimeout, false, w.address)
+}
+
+//Close 发送队列中的日志后停止后台goroutine并关闭连接，可重复调用
+func (w *syslogWriter) Close() error {
+	var err error
+	w.once.Do(func() {
+		//关闭后不再放入队列，队列中的日志由后台goroutine发送或计入丢弃
+		w.mu.Lock()
+		w.closed = true
+		w.mu.Unlock()
+
+		err = requestFlush(w.flush, w.done, syslogTimeout, true, w.address)
+		if err != nil {
+			close(w.quit)
+		}
+	})
+	return err
+}
+
+func (w *syslogWriter) run() {
+	defer close(w.done)
+	defer func() {
+		//超时未发送的日志计入丢弃
+		w.dropped.Add(float64(len(w.queue)))
+		w.closeConn()
+	}()
+
+	for {
+		select {
+		case data := <-w.queue:
+			w.sendOrDrop(data)
+		case req := <-w.flush:
+			req.done <- w.drain(req.ctx)
+			if req.stop {
+				return
+			}
+		case <-w.quit:
+			return
+		}
+	}
+}
+
+//drain 发送队列中的日志，ctx超时后剩余日志留在队列中
+func (w *syslogWriter) drain(ctx context.Context) error {
+	var err error
+	for len(w.queue) > 0 {
+		if cerr := ctx.Err(); cerr != nil {
+			return fmt.Errorf("sync syslog %s: %v", w.address, cerr)
+		}
+		if serr := w.sendOrDrop(<-w.queue); serr != nil {
+			err = serr
+		}
+	}
+	return err
+}
+
+func (w *syslogWriter) sendOrDrop(data []byte) error {
+	err := w.send(data)
+	if err != nil {
+		w.dropped.Inc()
+	}
+	return err
+}
+
+func (w *syslogWriter) closeConn() {
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+}
+
+//format <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+func (w *syslogWriter) format(level zapcore.Level, t time.Time, msg []byte) []byte {
+	msg = bytes.TrimRight(msg, "\r\n")
+	header := fmt.Sprintf("<%d>1 %s %s %s %d - - ", w.facility*8+syslogSeverity(level), t.Format(syslogTimeLayout), w.hostname, w.tag, w.pid)
+	line := append([]byte(header), msg...)
+	if w.network == "tcp" {
+		return append([]byte(fmt.Sprintf("%d ", len(line))), line...)
+	}
+	return line
+}
+
+func (w *syslogWriter) connect() (net.Conn, error) {
+	if w.network == "unix" {
+		//优先使用datagram，与/dev/log一致
+		if conn, err := net.DialTimeout("unixgram", w.address, syslogTimeout); err == nil {
+			return conn, nil
+		}
+	}
+	return net.DialTimeout(w.network, w.address, syslogTimeout)
+}
+
+//send 发送失败时重连一次，连接失败后syslogRetryInterval内不再连接，只在后台goroutine中调用
+func (w *syslogWriter) send(data []byte) error {
+	var err error
+	for i := 0; i < 2; i++ {
+		if w.conn == nil {
+			if time.Now().Before(w.retryAt) {
+				return fmt.Errorf("syslog %s unavailable", w.address)
+			}
+			if w.conn, err = w.connect(); err != nil {
+				w.conn = nil
+				w.retryAt = time.Now().Add(syslogRetryInterval)
+				return err
+			}
+		}
+		w.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+		if _, err = w.conn.Write(data); err == nil {
+			return nil
+		}
+		w.closeConn()
+	}
+	return err
+}
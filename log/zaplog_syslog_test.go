@@ -0,0 +1,78 @@
+package log
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestSyslogWriterClose(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer pc.Close()
+
+	cores, closers := newSinkCores(&LoggerOption{Sinks: []SinkOption{
+		{Type: SINK_SYSLOG, Network: "udp", Address: pc.LocalAddr().String(), Tag: "test"},
+	}})
+	if len(closers) != 1 {
+		t.Fatalf("closers = %d, want 1", len(closers))
+	}
+	w := closers[0].(*syslogWriter)
+
+	logger := zap.New(cores[0])
+	logger.Info("hello syslog")
+	if err := logger.Sync(); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	buf := make([]byte, 4096)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("read syslog: %v", err)
+	}
+	if msg := string(buf[:n]); !strings.HasPrefix(msg, "<14>1 ") || !strings.Contains(msg, "hello syslog") {
+		t.Fatalf("syslog message = %q", msg)
+	}
+
+	//关闭后停止后台goroutine并关闭连接
+	if err := w.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	select {
+	case <-w.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("syslog writer goroutine not stopped")
+	}
+	if w.conn != nil {
+		t.Fatal("syslog connection not closed")
+	}
+	logger.Info("after close")
+}
+
+func TestSyslogWriterUnavailable(t *testing.T) {
+	//tcp端口未监听，连接失败
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	address := ln.Addr().String()
+	ln.Close()
+
+	core, w := newSyslogCore(SinkOption{Type: SINK_SYSLOG, Network: "tcp", Address: address})
+	defer w.Close()
+
+	//syslog不可用时写日志不阻塞调用方
+	logger := zap.New(core)
+	start := time.Now()
+	for i := 0; i < 1000; i++ {
+		logger.Info("unavailable")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("logging took %v with unavailable syslog", elapsed)
+	}
+}
@@ -0,0 +1,281 @@
+package trc
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const (
+	DEFAULT_BAGGAGE_MAX_SIZE = 8192 //baggage缺省上限(字节)，与W3C baggage header上限一致
+
+	exemplarTraceID = "trace_id"
+)
+
+var (
+	baggageMu      sync.RWMutex
+	baggageKeys    []string
+	baggageMaxSize = DEFAULT_BAGGAGE_MAX_SIZE
+
+	baggageOptions sync.Map //tracer(opentracing.Tracer或*sdktrace.TracerProvider) -> *baggageOption
+)
+
+//baggageOption 调用链配置中的baggage key与上限，按tracer保存，未配置的项使用全局设置
+type baggageOption struct {
+	keys    []string
+	maxSize int
+}
+
+//SetBaggageKeys 设置全局的baggage key，如tenant_id、user_id，需要自动写入日志字段与prometheus exemplar，
+//调用链配置了BaggageKeys时，该tracer的span使用调用链配置
+func SetBaggageKeys(keys ...string) {
+	baggageMu.Lock()
+	defer baggageMu.Unlock()
+	baggageKeys = append([]string(nil), keys...)
+}
+
+//SetBaggageMaxSize 设置全局的baggage上限(全部key与value的字节数之和)，小于等于0时使用缺省值，
+//调用链配置了BaggageMaxSize时，该tracer的span使用调用链配置
+func SetBaggageMaxSize(size int) {
+	if size <= 0 {
+		size = DEFAULT_BAGGAGE_MAX_SIZE
+	}
+	baggageMu.Lock()
+	defer baggageMu.Unlock()
+	baggageMaxSize = size
+}
+
+//BaggageMaxSize 全局的baggage上限
+func BaggageMaxSize() int {
+	baggageMu.RLock()
+	defer baggageMu.RUnlock()
+	return baggageMaxSize
+}
+
+//BaggageLimit ctx当前span所属tracer的baggage上限，tracer未配置时为全局上限
+func BaggageLimit(ctx context.Context) int {
+	_, maxSize := contextBaggageOption(ctx)
+	return maxSize
+}
+
+//newBaggageOption 调用链配置中的baggage key与上限
+func newBaggageOption(o *TracerOption) *baggageOption {
+	bo := &baggageOption{maxSize: o.BaggageMaxSize}
+	for _, key := range o.BaggageKeys {
+		if key = strings.TrimSpace(key); key != "" {
+			bo.keys = append(bo.keys, key)
+		}
+	}
+	return bo
+}
+
+//contextBaggageOption ctx当前span所属tracer的baggage key与上限，未配置的项使用全局设置
+func contextBaggageOption(ctx context.Context) ([]string, int) {
+	var tracer interface{}
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		tracer = span.Tracer()
+	} else if span := trace.SpanFromContext(ctx); span.SpanContext().IsValid() {
+		tracer = span.TracerProvider()
+	}
+
+	baggageMu.RLock()
+	keys, maxSize := baggageKeys, baggageMaxSize
+	baggageMu.RUnlock()
+
+	if tracer != nil {
+		if v, ok := baggageOptions.Load(tracer); ok {
+			bo := v.(*baggageOption)
+			if len(bo.keys) > 0 {
+				keys = bo.keys
+			}
+			if bo.maxSize > 0 {
+				maxSize = bo.maxSize
+			}
+		}
+	}
+	return keys, maxSize
+}
+
+//SetBaggage 在调用链上设置baggage，随调用传递到下游服务，返回的ctx需继续使用
+//opentracing设置在ctx当前span上(没有span时放入otel baggage，不会传递)，otel设置在ctx的baggage中
+//设置后超过上限时通过trc模块日志输出警告并忽略本次设置
+func SetBaggage(ctx context.Context, key string, value string) context.Context {
+	items := BaggageItems(ctx)
+	size := baggageSize(items) - len(items[key]) + len(value)
+	if _, ok := items[key]; !ok {
+		size += len(key)
+	}
+	if limit := BaggageLimit(ctx); size > limit {
+		trcLogger().Warn("baggage size exceeds limit, item dropped", zap.String("key", key), zap.Int("size", size), zap.Int("limit", limit))
+		return ctx
+	}
+
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		span.SetBaggageItem(key, value)
+		return ctx
+	}
+
+	member, err := baggage.NewMemberRaw(key, value)
+	if err != nil {
+		trcLogger().Warn("invalid baggage", zap.String("key", key), zap.Error(err))
+		return ctx
+	}
+	b, err := baggage.FromContext(ctx).SetMember(member)
+	if err != nil {
+		trcLogger().Warn("invalid baggage", zap.String("key", key), zap.Error(err))
+		return ctx
+	}
+	return baggage.ContextWithBaggage(ctx, b)
+}
+
+//GetBaggage 获取baggage，不存在时返回空字符串
+func GetBaggage(ctx context.Context, key string) string {
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		if value := span.BaggageItem(key); value != "" {
+			return value
+		}
+	}
+	return baggage.FromContext(ctx).Member(key).Value()
+}
+
+//BaggageItems 获取全部baggage
+func BaggageItems(ctx context.Context) map[string]string {
+	items := make(map[string]string)
+	for _, member := range baggage.FromContext(ctx).Members() {
+		items[member.Key()] = member.Value()
+	}
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		span.Context().ForeachBaggageItem(func(k, v string) bool {
+			items[k] = v
+			return true
+		})
+	}
+	return items
+}
+
+//BaggageField 配置的baggage
+type BaggageField struct {
+	Key   string
+	Value string
+}
+
+//BaggageFields 获取ctx当前span所属tracer配置的baggage(未配置时为SetBaggageKeys设置的key)，按配置顺序返回有值的项
+func BaggageFields(ctx context.Context) []BaggageField {
+	keys, _ := contextBaggageOption(ctx)
+
+	fields := make([]BaggageField, 0, len(keys))
+	for _, key := range keys {
+		if value := GetBaggage(ctx, key); value != "" {
+			fields = append(fields, BaggageField{Key: key, Value: value})
+		}
+	}
+	return fields
+}
+
+//CheckBaggageSize 校验ctx中baggage的大小，超过上限时返回实际大小与false，用于发现上游传入的过大baggage
+func CheckBaggageSize(ctx context.Context) (int, bool) {
+	size := baggageSize(BaggageItems(ctx))
+	return size, size <= BaggageLimit(ctx)
+}
+
+//TrimBaggage baggage超过上限时删除超出的项，配置的baggage key优先保留，其余按key排序保留，返回新的ctx及删除的key；
+//opentracing从ctx当前span上删除，otel从ctx的baggage中删除，删除后不再写入日志与exemplar，也不再传递到下游
+func TrimBaggage(ctx context.Context) (context.Context, []string) {
+	items := BaggageItems(ctx)
+	keys, limit := contextBaggageOption(ctx)
+	if baggageSize(items) <= limit {
+		return ctx, nil
+	}
+
+	order := make([]string, 0, len(items))
+	kept := make(map[string]bool, len(items))
+	for _, key := range keys {
+		if _, ok := items[key]; ok && !kept[key] {
+			order = append(order, key)
+			kept[key] = true
+		}
+	}
+	rest := make([]string, 0, len(items))
+	for key := range items {
+		if !kept[key] {
+			rest = append(rest, key)
+		}
+	}
+	sort.Strings(rest)
+	order = append(order, rest...)
+
+	size := 0
+	var dropped []string
+	for _, key := range order {
+		n := len(key) + len(items[key])
+		if size+n > limit {
+			dropped = append(dropped, key)
+			continue
+		}
+		size += n
+	}
+
+	span := opentracing.SpanFromContext(ctx)
+	b := baggage.FromContext(ctx)
+	for _, key := range dropped {
+		if span != nil {
+			span.SetBaggageItem(key, "")
+		}
+		b = b.DeleteMember(key)
+	}
+	return baggage.ContextWithBaggage(ctx, b), dropped
+}
+
+//ExemplarLabels prometheus exemplar标签: trace_id及配置的baggage(key中非法字符替换为_)，
+//按顺序添加，超过exemplar长度上限(128个字符)、与已有标签重名(如trace-id)或以__开头(prometheus保留)的标签忽略；没有trace或trace未采样时返回nil，避免exemplar指向不存在的trace
+func ExemplarLabels(ctx context.Context) prometheus.Labels {
+	traceID := TraceID(ctx)
+	if traceID == "" || !Sampled(ctx) {
+		return nil
+	}
+
+	labels := prometheus.Labels{exemplarTraceID: traceID}
+	runes := utf8.RuneCountInString(exemplarTraceID) + utf8.RuneCountInString(traceID)
+	for _, field := range BaggageFields(ctx) {
+		name := labelName(field.Key)
+		if _, ok := labels[name]; ok || strings.HasPrefix(name, "__") {
+			continue
+		}
+		n := utf8.RuneCountInString(name) + utf8.RuneCountInString(field.Value)
+		if runes+n > prometheus.ExemplarMaxRunes {
+			continue
+		}
+		labels[name] = field.Value
+		runes += n
+	}
+	return labels
+}
+
+func baggageSize(items map[string]string) int {
+	size := 0
+	for k, v := range items {
+		size += len(k) + len(v)
+	}
+	return size
+}
+
+//labelName 替换prometheus标签名称中的非法字符
+func labelName(key string) string {
+	if key != "" && key[0] >= '0' && key[0] <= '9' {
+		key = "_" + key
+	}
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, key)
+}
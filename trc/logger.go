@@ -0,0 +1,21 @@
+package trc
+
+import (
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+var logger atomic.Value //*zap.Logger
+
+//SetLogger 设置trc包的日志，log包创建日志时自动设置为log.Named("trc")，未设置时使用zap.L()
+func SetLogger(l *zap.Logger) {
+	logger.Store(l)
+}
+
+func trcLogger() *zap.Logger {
+	if l, ok := logger.Load().(*zap.Logger); ok && l != nil {
+		return l
+	}
+	return zap.L().Named("trc")
+}
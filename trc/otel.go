@@ -0,0 +1,241 @@
+package trc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/credentials"
+)
+
+const (
+	OTLP_PROTOCOL_GRPC = "grpc" //OTLP/gRPC，collector缺省端口4317
+	OTLP_PROTOCOL_HTTP = "http" //OTLP/HTTP protobuf，collector缺省端口4318
+)
+
+var (
+	TracerProvider *sdktrace.TracerProvider //最近一次设置的全局provider，释放后为nil
+)
+
+//InitTracerProvider 按配置获取OpenTelemetry TracerProvider，同一服务名称共享同一个provider，见AcquireTracerProvider；
+//全局传递格式按Propagators设置，缺省使用W3C trace-context与baggage
+func InitTracerProvider(o *TracerOption) (*sdktrace.TracerProvider, error) {
+	tp, err := AcquireTracerProvider(o)
+	if err != nil {
+		fmt.Printf("create tracer provider fail! error<%v>\n", err)
+		return nil, err
+	}
+	return tp, nil
+}
+
+//TextMapPropagator 按配置创建的传递格式，SkipGlobal时需显式传给otelgrpc等组件
+func TextMapPropagator(o *TracerOption) propagation.TextMapPropagator {
+	return newOTelPropagator(o.Propagators)
+}
+
+func setGlobalTracerProvider(tp *sdktrace.TracerProvider, o *TracerOption) {
+	TracerProvider = tp
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(newOTelPropagator(o.Propagators))
+}
+
+//newTracerProvider 创建OTLP exporter及TracerProvider
+func newTracerProvider(o *TracerOption) (*sdktrace.TracerProvider, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	attrs := []attribute.KeyValue{attribute.String("service.name", o.ServiceName)}
+	for k, v := range o.Tags {
+		attrs = append(attrs, attribute.String(k, v))
+	}
+
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewSchemaless(attrs...)),
+		sdktrace.WithSampler(sdktrace.ParentBased(newOTelSampler(o))),
+	}
+	if o.Recorder != nil {
+		opts = append(opts, sdktrace.WithSpanProcessor(o.Recorder))
+	} else {
+		exporter, err := newOTLPExporter(o)
+		if err != nil {
+			return nil, err
+		}
+
+		batchOpts := make([]sdktrace.BatchSpanProcessorOption, 0)
+		if o.FlushInterval > 0 {
+			batchOpts = append(batchOpts, sdktrace.WithBatchTimeout(o.FlushInterval))
+		}
+		if o.QueueSize > 0 {
+			batchOpts = append(batchOpts, sdktrace.WithMaxQueueSize(o.QueueSize))
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter, batchOpts...))
+	}
+	if o.LogSpans {
+		opts = append(opts, sdktrace.WithSpanProcessor(logSpanProcessor{}))
+	}
+	return sdktrace.NewTracerProvider(opts...), nil
+}
+
+//newOTLPExporter 按OTLPProtocol创建grpc或http exporter，Addr为collector地址
+func newOTLPExporter(o *TracerOption) (sdktrace.SpanExporter, error) {
+	ctx := context.Background()
+
+	switch o.OTLPProtocol {
+	case OTLP_PROTOCOL_HTTP:
+		opts := make([]otlptracehttp.Option, 0)
+		if strings.Contains(o.Addr, "://") {
+			opts = append(opts, otlptracehttp.WithEndpointURL(o.Addr))
+		} else {
+			opts = append(opts, otlptracehttp.WithEndpoint(o.Addr))
+		}
+		if !o.OTLPTLS {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		if len(o.OTLPHeaders) > 0 {
+			opts = append(opts, otlptracehttp.WithHeaders(o.OTLPHeaders))
+		}
+		return otlptracehttp.New(ctx, opts...)
+	default:
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(o.Addr)}
+		if o.OTLPTLS {
+			opts = append(opts, otlptracegrpc.WithTLSCredentials(credentials.NewClientTLSFromCert(nil, "")))
+		} else {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		if len(o.OTLPHeaders) > 0 {
+			opts = append(opts, otlptracegrpc.WithHeaders(o.OTLPHeaders))
+		}
+		return otlptracegrpc.New(ctx, opts...)
+	}
+}
+
+//newOTelSampler 将SamplerType转换为OpenTelemetry采样器，用于没有上游调用链的请求
+func newOTelSampler(o *TracerOption) sdktrace.Sampler {
+	switch o.SamplerType {
+	case SAMPLER_PROBABILISTIC:
+		return sdktrace.TraceIDRatioBased(o.SamplerParam)
+	case SAMPLER_RATE_LIMITING:
+		return newRateLimitingSampler(o.SamplerParam)
+	case SAMPLER_OPERATION:
+		s := &operationSampler{
+			defaultSampler: sdktrace.TraceIDRatioBased(o.SamplerParam),
+			samplers:       make(map[string]sdktrace.Sampler),
+		}
+		for op, rate := range o.OperationSampling {
+			s.samplers[op] = sdktrace.TraceIDRatioBased(rate)
+		}
+		return s
+	case SAMPLER_REMOTE:
+		fmt.Printf("remote sampler is not supported by otel tracer, use probabilistic sampler with param %v\n", o.SamplerParam)
+		return sdktrace.TraceIDRatioBased(o.SamplerParam)
+	default:
+		if o.SamplerParam == 0 {
+			return sdktrace.NeverSample()
+		}
+		return sdktrace.AlwaysSample()
+	}
+}
+
+//operationSampler 按span名称(grpc全方法名)使用不同的采样概率
+type operationSampler struct {
+	defaultSampler sdktrace.Sampler
+	samplers       map[string]sdktrace.Sampler
+}
+
+func (s *operationSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	if sampler, ok := s.samplers[p.Name]; ok {
+		return sampler.ShouldSample(p)
+	}
+	return s.defaultSampler.ShouldSample(p)
+}
+
+func (s *operationSampler) Description() string {
+	return "OperationSampler"
+}
+
+//rateLimitingSampler 每秒最多采样maxPerSecond个调用链
+type rateLimitingSampler struct {
+	mu           sync.Mutex
+	maxPerSecond float64
+	balance      float64
+	last         time.Time
+}
+
+func newRateLimitingSampler(maxPerSecond float64) *rateLimitingSampler {
+	return &rateLimitingSampler{
+		maxPerSecond: maxPerSecond,
+		balance:      maxBalance(maxPerSecond),
+		last:         time.Now(),
+	}
+}
+
+//maxBalance 令牌上限，至少为1以便低频限速也能采样
+func maxBalance(maxPerSecond float64) float64 {
+	if maxPerSecond < 1 {
+		return 1
+	}
+	return maxPerSecond
+}
+
+func (s *rateLimitingSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	psc := trace.SpanContextFromContext(p.ParentContext)
+
+	s.mu.Lock()
+	now := time.Now()
+	s.balance += now.Sub(s.last).Seconds() * s.maxPerSecond
+	if max := maxBalance(s.maxPerSecond); s.balance > max {
+		s.balance = max
+	}
+	s.last = now
+
+	decision := sdktrace.Drop
+	if s.balance >= 1 {
+		s.balance--
+		decision = sdktrace.RecordAndSample
+	}
+	s.mu.Unlock()
+
+	return sdktrace.SamplingResult{Decision: decision, Tracestate: psc.TraceState()}
+}
+
+func (s *rateLimitingSampler) Description() string {
+	return fmt.Sprintf("RateLimitingSampler{%v}", s.maxPerSecond)
+}
+
+//logSpanProcessor 打印结束的span，用于调试
+type logSpanProcessor struct{}
+
+func (logSpanProcessor) OnStart(parent context.Context, s sdktrace.ReadWriteSpan) {}
+
+func (logSpanProcessor) OnEnd(s sdktrace.ReadOnlySpan) {
+	sc := s.SpanContext()
+	fmt.Printf("Reporting span %s:%s:%s:%v %s\n", sc.TraceID(), sc.SpanID(), s.Parent().SpanID(), sc.IsSampled(), s.Name())
+}
+
+func (logSpanProcessor) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func (logSpanProcessor) ForceFlush(ctx context.Context) error {
+	return nil
+}
+
+//shutdownTracerProvider 上报剩余的span并关闭exporter
+func shutdownTracerProvider(tp *sdktrace.TracerProvider) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tp.Shutdown(ctx); err != nil {
+		fmt.Printf("shutdown tracer provider fail! error<%v>\n", err)
+	}
+}
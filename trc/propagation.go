@@ -0,0 +1,292 @@
+package trc
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/uber/jaeger-client-go"
+	"github.com/uber/jaeger-client-go/zipkin"
+	"go.opentelemetry.io/contrib/propagators/b3"
+	jaegerprop "go.opentelemetry.io/contrib/propagators/jaeger"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+const (
+	PROPAGATOR_JAEGER   = "jaeger"  //uber-trace-id及uberctx-前缀的baggage
+	PROPAGATOR_B3       = "b3"      //zipkin b3单header
+	PROPAGATOR_B3_MULTI = "b3multi" //zipkin x-b3-*多header
+	PROPAGATOR_W3C      = "w3c"     //W3C traceparent及baggage
+
+	b3SingleHeader    = "b3"
+	traceparentHeader = "traceparent"
+	baggageHeader     = "baggage"
+)
+
+//validatePropagators 校验传递格式名称
+func validatePropagators(names []string) error {
+	for _, name := range names {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case PROPAGATOR_JAEGER, PROPAGATOR_B3, PROPAGATOR_B3_MULTI, PROPAGATOR_W3C:
+		default:
+			return fmt.Errorf("tracer config: invalid Propagators %q, expect jaeger, b3, b3multi or w3c", name)
+		}
+	}
+	return nil
+}
+
+//newOTelPropagator 创建OpenTelemetry组合传递格式，未配置时使用W3C trace-context与baggage
+func newOTelPropagator(names []string) propagation.TextMapPropagator {
+	if len(names) == 0 {
+		names = []string{PROPAGATOR_W3C}
+	}
+
+	propagators := make([]propagation.TextMapPropagator, 0, len(names))
+	for _, name := range names {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case PROPAGATOR_JAEGER:
+			propagators = append(propagators, jaegerprop.Jaeger{})
+		case PROPAGATOR_B3:
+			propagators = append(propagators, b3.New(b3.WithInjectEncoding(b3.B3SingleHeader)))
+		case PROPAGATOR_B3_MULTI:
+			propagators = append(propagators, b3.New(b3.WithInjectEncoding(b3.B3MultipleHeader)))
+		case PROPAGATOR_W3C:
+			propagators = append(propagators, propagation.TraceContext{}, propagation.Baggage{})
+		}
+	}
+	return propagation.NewCompositeTextMapPropagator(propagators...)
+}
+
+//compositePropagator jaeger tracer的组合传递格式，注入时写入全部格式，提取时按配置顺序使用第一个有效的格式
+type compositePropagator struct {
+	injectors  []jaeger.Injector
+	extractors []jaeger.Extractor
+}
+
+//newCompositePropagator 按名称创建jaeger tracer的组合传递格式
+func newCompositePropagator(names []string) *compositePropagator {
+	p := &compositePropagator{}
+	for _, name := range names {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case PROPAGATOR_JAEGER:
+			jp := jaeger.NewHTTPHeaderPropagator(new(jaeger.HeadersConfig).ApplyDefaults(), *jaeger.NewNullMetrics())
+			p.add(jp, jp)
+		case PROPAGATOR_B3:
+			p.add(b3SinglePropagator{}, b3SinglePropagator{})
+		case PROPAGATOR_B3_MULTI:
+			zp := zipkin.NewZipkinB3HTTPHeaderPropagator()
+			p.add(zp, zp)
+		case PROPAGATOR_W3C:
+			p.add(w3cPropagator{}, w3cPropagator{})
+		}
+	}
+	return p
+}
+
+func (p *compositePropagator) add(injector jaeger.Injector, extractor jaeger.Extractor) {
+	p.injectors = append(p.injectors, injector)
+	p.extractors = append(p.extractors, extractor)
+}
+
+//Inject 写入全部格式
+func (p *compositePropagator) Inject(sc jaeger.SpanContext, carrier interface{}) error {
+	for _, injector := range p.injectors {
+		if err := injector.Inject(sc, carrier); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Extract 按顺序提取，返回第一个有效的调用链上下文
+func (p *compositePropagator) Extract(carrier interface{}) (jaeger.SpanContext, error) {
+	var firstErr error
+	for _, extractor := range p.extractors {
+		sc, err := extractor.Extract(carrier)
+		if err == nil && sc.IsValid() {
+			return sc, nil
+		}
+		if err != nil && err != opentracing.ErrSpanContextNotFound && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if firstErr != nil {
+		return jaeger.SpanContext{}, firstErr
+	}
+	return jaeger.SpanContext{}, opentracing.ErrSpanContextNotFound
+}
+
+//b3SinglePropagator b3: {traceid}-{spanid}-{sampled}-{parentspanid}
+type b3SinglePropagator struct{}
+
+func (b3SinglePropagator) Inject(sc jaeger.SpanContext, carrier interface{}) error {
+	writer, ok := carrier.(opentracing.TextMapWriter)
+	if !ok {
+		return opentracing.ErrInvalidCarrier
+	}
+
+	sampled := "0"
+	if sc.IsSampled() {
+		sampled = "1"
+	}
+	value := sc.TraceID().String() + "-" + sc.SpanID().String() + "-" + sampled
+	if sc.ParentID() != 0 {
+		value += "-" + sc.ParentID().String()
+	}
+	writer.Set(b3SingleHeader, value)
+	return nil
+}
+
+func (b3SinglePropagator) Extract(carrier interface{}) (jaeger.SpanContext, error) {
+	reader, ok := carrier.(opentracing.TextMapReader)
+	if !ok {
+		return jaeger.SpanContext{}, opentracing.ErrInvalidCarrier
+	}
+
+	value := readHeader(reader, b3SingleHeader)
+	if value == "" {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextNotFound
+	}
+
+	//只有采样标记时没有可用的调用链上下文
+	parts := strings.Split(value, "-")
+	if len(parts) < 2 {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextNotFound
+	}
+
+	traceID, err := jaeger.TraceIDFromString(parts[0])
+	if err != nil {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextCorrupted
+	}
+	spanID, err := jaeger.SpanIDFromString(parts[1])
+	if err != nil {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextCorrupted
+	}
+
+	sampled := false
+	if len(parts) > 2 {
+		sampled = parts[2] == "1" || parts[2] == "d"
+	}
+
+	var parentID jaeger.SpanID
+	if len(parts) > 3 {
+		if parentID, err = jaeger.SpanIDFromString(parts[3]); err != nil {
+			return jaeger.SpanContext{}, opentracing.ErrSpanContextCorrupted
+		}
+	}
+	return jaeger.NewSpanContext(traceID, spanID, parentID, sampled, nil), nil
+}
+
+//w3cPropagator traceparent: 00-{traceid}-{spanid}-{flags}，baggage: k1=v1,k2=v2
+type w3cPropagator struct{}
+
+func (w3cPropagator) Inject(sc jaeger.SpanContext, carrier interface{}) error {
+	writer, ok := carrier.(opentracing.TextMapWriter)
+	if !ok {
+		return opentracing.ErrInvalidCarrier
+	}
+
+	flags := 0
+	if sc.IsSampled() {
+		flags = 1
+	}
+	traceID := sc.TraceID()
+	writer.Set(traceparentHeader, fmt.Sprintf("00-%016x%016x-%016x-%02x", traceID.High, traceID.Low, uint64(sc.SpanID()), flags))
+
+	members := make([]string, 0)
+	sc.ForeachBaggageItem(func(k, v string) bool {
+		members = append(members, escapeBaggage(k)+"="+escapeBaggage(v))
+		return true
+	})
+	if len(members) > 0 {
+		writer.Set(baggageHeader, strings.Join(members, ","))
+	}
+	return nil
+}
+
+func (w3cPropagator) Extract(carrier interface{}) (jaeger.SpanContext, error) {
+	reader, ok := carrier.(opentracing.TextMapReader)
+	if !ok {
+		return jaeger.SpanContext{}, opentracing.ErrInvalidCarrier
+	}
+
+	value := readHeader(reader, traceparentHeader)
+	if value == "" {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextNotFound
+	}
+
+	parts := strings.Split(value, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextCorrupted
+	}
+
+	traceID, err := jaeger.TraceIDFromString(parts[1])
+	if err != nil || !traceID.IsValid() {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextCorrupted
+	}
+	spanID, err := jaeger.SpanIDFromString(parts[2])
+	if err != nil || spanID == 0 {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextCorrupted
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextCorrupted
+	}
+
+	return jaeger.NewSpanContext(traceID, spanID, 0, flags&1 == 1, parseW3CBaggage(readHeader(reader, baggageHeader))), nil
+}
+
+//parseW3CBaggage 解析W3C baggage header，忽略成员属性
+func parseW3CBaggage(value string) map[string]string {
+	if value == "" {
+		return nil
+	}
+
+	baggage := make(map[string]string)
+	for _, member := range strings.Split(value, ",") {
+		if i := strings.Index(member, ";"); i >= 0 {
+			member = member[:i]
+		}
+		i := strings.Index(member, "=")
+		if i <= 0 {
+			continue
+		}
+		k, err1 := url.PathUnescape(strings.TrimSpace(member[:i]))
+		v, err2 := url.PathUnescape(strings.TrimSpace(member[i+1:]))
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		baggage[k] = v
+	}
+	return baggage
+}
+
+//escapeBaggage W3C baggage百分号编码，只保留baggage-octet中的字符(不含%)，
+//空格编码为%20而不是+，+等字符原样保留，与otel的baggage传递格式一致
+func escapeBaggage(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == 0x21 || c >= 0x23 && c <= 0x2B && c != '%' || c >= 0x2D && c <= 0x3A || c >= 0x3C && c <= 0x5B || c >= 0x5D && c <= 0x7E {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+//readHeader 读取header，grpc metadata中的key均为小写
+func readHeader(reader opentracing.TextMapReader, name string) string {
+	var value string
+	reader.ForeachKey(func(key, val string) error {
+		if value == "" && strings.ToLower(key) == name {
+			value = val
+		}
+		return nil
+	})
+	return value
+}
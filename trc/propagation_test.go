@@ -0,0 +1,56 @@
+package trc
+
+import (
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/uber/jaeger-client-go"
+	"go.opentelemetry.io/otel/baggage"
+)
+
+func TestW3CBaggageRoundTrip(t *testing.T) {
+	traceID, _ := jaeger.TraceIDFromString("4bf92f3577b34da6a3ce929d0e0e4736")
+	items := map[string]string{"user": "a b+c,d;e%é"}
+	sc := jaeger.NewSpanContext(traceID, jaeger.SpanID(0x00f067aa0ba902b7), 0, true, items)
+
+	carrier := opentracing.TextMapCarrier{}
+	if err := (w3cPropagator{}).Inject(sc, carrier); err != nil {
+		t.Fatalf("inject: %v", err)
+	}
+	if got, want := carrier[traceparentHeader], "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"; got != want {
+		t.Fatalf("traceparent = %s, want %s", got, want)
+	}
+	//空格编码为%20，+原样保留
+	const header = "user=a%20b+c%2Cd%3Be%25%C3%A9"
+	if got := carrier[baggageHeader]; got != header {
+		t.Fatalf("baggage = %s, want %s", got, header)
+	}
+
+	//otel按W3C规范解析得到相同的值
+	b, err := baggage.Parse(header)
+	if err != nil {
+		t.Fatalf("otel parse: %v", err)
+	}
+	if v := b.Member("user").Value(); v != items["user"] {
+		t.Fatalf("otel baggage = %q, want %q", v, items["user"])
+	}
+
+	extracted, err := (w3cPropagator{}).Extract(carrier)
+	if err != nil {
+		t.Fatalf("extract: %v", err)
+	}
+	extracted.ForeachBaggageItem(func(k, v string) bool {
+		if k != "user" || v != items["user"] {
+			t.Fatalf("extracted baggage %s = %q, want user = %q", k, v, items["user"])
+		}
+		return true
+	})
+}
+
+func TestParseW3CBaggage(t *testing.T) {
+	//+不是空格，属性忽略
+	got := parseW3CBaggage("k1=v1, k2 = a%20b+c;prop=1,invalid,k3=%zz")
+	if len(got) != 2 || got["k1"] != "v1" || got["k2"] != "a b+c" {
+		t.Fatalf("baggage = %v", got)
+	}
+}
@@ -0,0 +1,248 @@
+package trc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/uber/jaeger-client-go"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//RecordedSpan 记录到内存的span，opentracing与otel统一格式
+type RecordedSpan struct {
+	TraceID       string
+	SpanID        string
+	ParentID      string //根span为空
+	OperationName string
+	Kind          string //client|server，与span.kind一致
+	Tags          map[string]interface{}
+	Events        []RecordedEvent
+	Error         bool
+	StartTime     time.Time
+	Duration      time.Duration
+}
+
+//RecordedEvent span上的事件，opentracing为LogKV中event字段
+type RecordedEvent struct {
+	Name   string
+	Fields map[string]interface{}
+}
+
+//Tag 获取tag，不存在时返回nil
+func (s *RecordedSpan) Tag(key string) interface{} {
+	return s.Tags[key]
+}
+
+//IsChildOf 是否为parent的直接子span
+func (s *RecordedSpan) IsChildOf(parent *RecordedSpan) bool {
+	return parent != nil && s.TraceID == parent.TraceID && s.ParentID == parent.SpanID
+}
+
+func (s *RecordedSpan) String() string {
+	return fmt.Sprintf("%s(%s) trace=%s span=%s parent=%s error=%v tags=%v", s.OperationName, s.Kind, s.TraceID, s.SpanID, s.ParentID, s.Error, s.Tags)
+}
+
+//SpanRecorder 将结束的span记录到内存，用于测试中校验调用链，不需要jaeger或collector
+//通过TracerOption.Recorder设置，opentracing时作为jaeger reporter，otel时作为span processor，span结束时同步记录
+type SpanRecorder struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	spans []*RecordedSpan
+}
+
+//NewSpanRecorder 创建内存span记录器
+func NewSpanRecorder() *SpanRecorder {
+	r := &SpanRecorder{}
+	r.cond = sync.NewCond(&r.mu)
+	return r
+}
+
+func (r *SpanRecorder) add(span *RecordedSpan) {
+	r.mu.Lock()
+	r.spans = append(r.spans, span)
+	r.mu.Unlock()
+	r.cond.Broadcast()
+}
+
+//Spans 已结束的全部span，按结束顺序排列
+func (r *SpanRecorder) Spans() []*RecordedSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*RecordedSpan(nil), r.spans...)
+}
+
+//Reset 清除已记录的span
+func (r *SpanRecorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = nil
+}
+
+//FindSpans 按名称查找span，忽略名称开头的"/"，即"/order.OrderService/Get"与otel的"order.OrderService/Get"均可匹配
+func (r *SpanRecorder) FindSpans(operationName string) []*RecordedSpan {
+	return findSpans(r.Spans(), operationName, "")
+}
+
+//FindSpan 按名称及kind(client|server，为空时不限)查找第一个span，不存在时返回nil
+func (r *SpanRecorder) FindSpan(operationName string, kind string) *RecordedSpan {
+	if spans := findSpans(r.Spans(), operationName, kind); len(spans) > 0 {
+		return spans[0]
+	}
+	return nil
+}
+
+//Children parent的直接子span
+func (r *SpanRecorder) Children(parent *RecordedSpan) []*RecordedSpan {
+	result := make([]*RecordedSpan, 0)
+	for _, span := range r.Spans() {
+		if span.IsChildOf(parent) {
+			result = append(result, span)
+		}
+	}
+	return result
+}
+
+//WaitForSpans 等待至少n个span结束，超时返回false；服务端span可能在客户端调用返回后才结束
+func (r *SpanRecorder) WaitForSpans(n int, timeout time.Duration) bool {
+	return r.wait(timeout, func() bool {
+		return len(r.spans) >= n
+	})
+}
+
+//WaitForSpan 等待指定名称及kind的span结束，超时返回nil
+func (r *SpanRecorder) WaitForSpan(operationName string, kind string, timeout time.Duration) *RecordedSpan {
+	var span *RecordedSpan
+	r.wait(timeout, func() bool {
+		if spans := findSpans(r.spans, operationName, kind); len(spans) > 0 {
+			span = spans[0]
+			return true
+		}
+		return false
+	})
+	return span
+}
+
+//wait 等待done返回true，done在持有锁时调用
+func (r *SpanRecorder) wait(timeout time.Duration, done func() bool) bool {
+	//持有锁时广播，避免在done检查与cond.Wait之间触发导致唤醒丢失
+	timer := time.AfterFunc(timeout, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.cond.Broadcast()
+	})
+	defer timer.Stop()
+
+	deadline := time.Now().Add(timeout)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for !done() {
+		if !time.Now().Before(deadline) {
+			return false
+		}
+		r.cond.Wait()
+	}
+	return true
+}
+
+func findSpans(spans []*RecordedSpan, operationName string, kind string) []*RecordedSpan {
+	name := strings.TrimPrefix(operationName, "/")
+	result := make([]*RecordedSpan, 0)
+	for _, span := range spans {
+		if strings.TrimPrefix(span.OperationName, "/") == name && (kind == "" || span.Kind == kind) {
+			result = append(result, span)
+		}
+	}
+	return result
+}
+
+//Report 实现jaeger.Reporter
+func (r *SpanRecorder) Report(span *jaeger.Span) {
+	sc := span.SpanContext()
+	rs := &RecordedSpan{
+		TraceID:       sc.TraceID().String(),
+		SpanID:        sc.SpanID().String(),
+		OperationName: span.OperationName(),
+		Tags:          span.Tags(),
+		StartTime:     span.StartTime(),
+		Duration:      span.Duration(),
+	}
+	if sc.ParentID() != 0 {
+		rs.ParentID = sc.ParentID().String()
+	}
+	if kind, ok := rs.Tags[string(ext.SpanKind)]; ok {
+		rs.Kind = fmt.Sprint(kind)
+	}
+	if isErr, ok := rs.Tags[string(ext.Error)].(bool); ok {
+		rs.Error = isErr
+	}
+
+	for _, record := range span.Logs() {
+		event := RecordedEvent{Fields: make(map[string]interface{}, len(record.Fields))}
+		for _, field := range record.Fields {
+			if field.Key() == "event" {
+				event.Name = fmt.Sprint(field.Value())
+				continue
+			}
+			event.Fields[field.Key()] = field.Value()
+		}
+		rs.Events = append(rs.Events, event)
+	}
+	r.add(rs)
+}
+
+//Close 实现jaeger.Reporter
+func (r *SpanRecorder) Close() {}
+
+//OnStart 实现sdktrace.SpanProcessor
+func (r *SpanRecorder) OnStart(parent context.Context, s sdktrace.ReadWriteSpan) {}
+
+//OnEnd 实现sdktrace.SpanProcessor
+func (r *SpanRecorder) OnEnd(s sdktrace.ReadOnlySpan) {
+	sc := s.SpanContext()
+	rs := &RecordedSpan{
+		TraceID:       sc.TraceID().String(),
+		SpanID:        sc.SpanID().String(),
+		OperationName: s.Name(),
+		Tags:          make(map[string]interface{}),
+		Error:         s.Status().Code == codes.Error,
+		StartTime:     s.StartTime(),
+		Duration:      s.EndTime().Sub(s.StartTime()),
+	}
+	if s.Parent().IsValid() {
+		rs.ParentID = s.Parent().SpanID().String()
+	}
+	switch s.SpanKind() {
+	case trace.SpanKindClient:
+		rs.Kind = string(ext.SpanKindRPCClientEnum)
+	case trace.SpanKindServer:
+		rs.Kind = string(ext.SpanKindRPCServerEnum)
+	}
+
+	for _, attr := range s.Attributes() {
+		rs.Tags[string(attr.Key)] = attr.Value.AsInterface()
+	}
+	for _, e := range s.Events() {
+		event := RecordedEvent{Name: e.Name, Fields: make(map[string]interface{}, len(e.Attributes))}
+		for _, attr := range e.Attributes {
+			event.Fields[string(attr.Key)] = attr.Value.AsInterface()
+		}
+		rs.Events = append(rs.Events, event)
+	}
+	r.add(rs)
+}
+
+//Shutdown 实现sdktrace.SpanProcessor
+func (r *SpanRecorder) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+//ForceFlush 实现sdktrace.SpanProcessor
+func (r *SpanRecorder) ForceFlush(ctx context.Context) error {
+	return nil
+}
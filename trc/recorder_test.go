@@ -0,0 +1,184 @@
+package trc
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//newRecorderOption 测试用调用链配置，全部采样，不设置全局tracer
+func newRecorderOption(t *testing.T) (*TracerOption, *SpanRecorder) {
+	recorder := NewSpanRecorder()
+	o := NewTracerOption(t.Name(), "")
+	o.SamplerType = SAMPLER_CONST
+	o.SamplerParam = 1
+	o.SkipGlobal = true
+	o.Recorder = recorder
+	return o, recorder
+}
+
+func TestSpanRecorderOpentracing(t *testing.T) {
+	o, recorder := newRecorderOption(t)
+	tracer, err := AcquireTracer(o)
+	if err != nil {
+		t.Fatalf("acquire tracer: %v", err)
+	}
+	defer ReleaseTracer(o.ServiceName)
+
+	parent := tracer.StartSpan("/order.OrderService/Get", ext.SpanKindRPCClient)
+	ctx := opentracing.ContextWithSpan(context.Background(), parent)
+	child := tracer.StartSpan("/order.OrderService/Get", opentracing.ChildOf(parent.Context()), ext.SpanKindRPCServer)
+	childCtx := opentracing.ContextWithSpan(ctx, child)
+	SetTag(childCtx, "order.id", 42)
+	AddEvent(childCtx, "cache miss", "key", "order-42")
+	ext.Error.Set(child, true)
+	child.Finish()
+	parent.Finish()
+
+	if spans := recorder.Spans(); len(spans) != 2 {
+		t.Fatalf("recorded %d spans, want 2", len(spans))
+	}
+	client := recorder.FindSpan("order.OrderService/Get", "client")
+	server := recorder.FindSpan("/order.OrderService/Get", "server")
+	if client == nil || server == nil {
+		t.Fatalf("client %v or server %v span not found", client, server)
+	}
+	if !server.IsChildOf(client) || client.ParentID != "" {
+		t.Fatalf("server %v is not child of root client %v", server, client)
+	}
+	if children := recorder.Children(client); len(children) != 1 || children[0] != server {
+		t.Fatalf("children of client = %v", children)
+	}
+	if !server.Error || client.Error {
+		t.Fatalf("error flags: server %v, client %v", server.Error, client.Error)
+	}
+	if v := server.Tag("order.id"); v != 42 {
+		t.Fatalf("tag order.id = %v", v)
+	}
+	if len(server.Events) != 1 || server.Events[0].Name != "cache miss" || server.Events[0].Fields["key"] != "order-42" {
+		t.Fatalf("events = %+v", server.Events)
+	}
+
+	recorder.Reset()
+	if spans := recorder.Spans(); len(spans) != 0 {
+		t.Fatalf("recorded %d spans after reset", len(spans))
+	}
+}
+
+func TestSpanRecorderOTel(t *testing.T) {
+	o, recorder := newRecorderOption(t)
+	tp, err := AcquireTracerProvider(o)
+	if err != nil {
+		t.Fatalf("acquire tracer provider: %v", err)
+	}
+	defer ReleaseTracerProvider(o.ServiceName)
+
+	tracer := tp.Tracer("trc")
+	ctx, parent := tracer.Start(context.Background(), "order.OrderService/Get", trace.WithSpanKind(trace.SpanKindClient))
+	childCtx, child := tracer.Start(ctx, "order.OrderService/Get", trace.WithSpanKind(trace.SpanKindServer))
+	SetTag(childCtx, "order.id", 42)
+	AddEvent(childCtx, "cache miss", "key", "order-42")
+	child.SetStatus(codes.Error, "failed")
+	child.End()
+	parent.End()
+
+	client := recorder.FindSpan("/order.OrderService/Get", "client")
+	server := recorder.FindSpan("order.OrderService/Get", "server")
+	if client == nil || server == nil {
+		t.Fatalf("client %v or server %v span not found", client, server)
+	}
+	if !server.IsChildOf(client) {
+		t.Fatalf("server %v is not child of client %v", server, client)
+	}
+	if !server.Error || client.Error {
+		t.Fatalf("error flags: server %v, client %v", server.Error, client.Error)
+	}
+	if v := server.Tag("order.id"); v != int64(42) {
+		t.Fatalf("tag order.id = %#v", v)
+	}
+	if len(server.Events) != 1 || server.Events[0].Name != "cache miss" || server.Events[0].Fields["key"] != "order-42" {
+		t.Fatalf("events = %+v", server.Events)
+	}
+}
+
+func TestSpanRecorderWait(t *testing.T) {
+	o, recorder := newRecorderOption(t)
+	tracer, err := AcquireTracer(o)
+	if err != nil {
+		t.Fatalf("acquire tracer: %v", err)
+	}
+	defer ReleaseTracer(o.ServiceName)
+
+	if recorder.WaitForSpans(1, 10*time.Millisecond) {
+		t.Fatalf("WaitForSpans returned true without spans")
+	}
+	if span := recorder.WaitForSpan("late", "", 10*time.Millisecond); span != nil {
+		t.Fatalf("WaitForSpan = %v, want nil", span)
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		tracer.StartSpan("late").Finish()
+	}()
+	if span := recorder.WaitForSpan("late", "", 5*time.Second); span == nil {
+		t.Fatalf("late span not found")
+	}
+}
+
+//TestSpanRecorderWaitTimeout 超时唤醒不会丢失，大量极短超时的等待均能返回
+func TestSpanRecorderWaitTimeout(t *testing.T) {
+	recorder := NewSpanRecorder()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					recorder.WaitForSpans(1, time.Duration(j%3)*time.Microsecond)
+				}
+			}()
+		}
+		wg.Wait()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("WaitForSpans did not time out")
+	}
+}
+
+func TestAcquireTracerRecorderMismatch(t *testing.T) {
+	o, first := newRecorderOption(t)
+	tracer, err := AcquireTracer(o)
+	if err != nil {
+		t.Fatalf("acquire tracer: %v", err)
+	}
+	defer ReleaseTracer(o.ServiceName)
+
+	//同名tracer复用第一个记录器，第二个记录器不生效
+	o2, second := newRecorderOption(t)
+	shared, err := AcquireTracer(o2)
+	if err != nil {
+		t.Fatalf("acquire tracer: %v", err)
+	}
+	defer ReleaseTracer(o2.ServiceName)
+	if shared != tracer {
+		t.Fatalf("expect tracer shared by service name")
+	}
+
+	shared.StartSpan("op").Finish()
+	if len(first.Spans()) != 1 || len(second.Spans()) != 0 {
+		t.Fatalf("first recorded %d, second recorded %d", len(first.Spans()), len(second.Spans()))
+	}
+}
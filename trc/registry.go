@@ -0,0 +1,187 @@
+package trc
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/opentracing/opentracing-go"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+//tracerEntry 按服务名称共享的tracer及引用计数
+type tracerEntry struct {
+	tracer   opentracing.Tracer
+	closer   io.Closer
+	provider *sdktrace.TracerProvider
+	recorder *SpanRecorder
+	refs     int
+}
+
+var (
+	registryMu sync.Mutex
+	tracers    = make(map[string]*tracerEntry)
+	providers  = make(map[string]*tracerEntry)
+)
+
+//AcquireTracer 获取服务名称对应的jaeger tracer，已存在时直接复用(忽略本次配置)并增加引用计数，
+//使用完毕后需调用ReleaseTracer；SkipGlobal为false时设置为opentracing全局tracer；
+//BaggageKeys与BaggageMaxSize只对该tracer的span生效
+func AcquireTracer(o *TracerOption) (opentracing.Tracer, error) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	entry, ok := tracers[o.ServiceName]
+	if !ok {
+		tracer, closer, err := newTracer(o)
+		if err != nil {
+			return nil, err
+		}
+		entry = &tracerEntry{tracer: tracer, closer: closer, recorder: o.Recorder}
+		tracers[o.ServiceName] = entry
+		baggageOptions.Store(tracer, newBaggageOption(o))
+	} else {
+		checkRecorder(entry, o)
+	}
+	entry.refs++
+
+	if !o.SkipGlobal {
+		Tracer = entry.tracer
+		Closer = entry.closer
+		opentracing.SetGlobalTracer(entry.tracer)
+	}
+	return entry.tracer, nil
+}
+
+//ReleaseTracer 减少引用计数，最后一个使用者释放时上报剩余span并关闭tracer，
+//该tracer为全局tracer时恢复为noop tracer
+func ReleaseTracer(serviceName string) {
+	registryMu.Lock()
+	entry, ok := tracers[serviceName]
+	if ok {
+		entry.refs--
+		if entry.refs > 0 {
+			ok = false
+		} else {
+			delete(tracers, serviceName)
+			resetGlobal(entry)
+		}
+	}
+	registryMu.Unlock()
+
+	if ok {
+		entry.closer.Close()
+	}
+}
+
+//AcquireTracerProvider 获取服务名称对应的OpenTelemetry TracerProvider，已存在时直接复用并增加引用计数，
+//使用完毕后需调用ReleaseTracerProvider；SkipGlobal为false时设置为otel全局provider及传递格式
+func AcquireTracerProvider(o *TracerOption) (*sdktrace.TracerProvider, error) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	entry, ok := providers[o.ServiceName]
+	if !ok {
+		tp, err := newTracerProvider(o)
+		if err != nil {
+			return nil, err
+		}
+		entry = &tracerEntry{provider: tp, recorder: o.Recorder}
+		providers[o.ServiceName] = entry
+		baggageOptions.Store(tp, newBaggageOption(o))
+	} else {
+		checkRecorder(entry, o)
+	}
+	entry.refs++
+
+	if !o.SkipGlobal {
+		setGlobalTracerProvider(entry.provider, o)
+	}
+	return entry.provider, nil
+}
+
+//ReleaseTracerProvider 减少引用计数，最后一个使用者释放时上报剩余span并关闭exporter，
+//该provider为全局provider时恢复为noop provider
+func ReleaseTracerProvider(serviceName string) {
+	registryMu.Lock()
+	entry, ok := providers[serviceName]
+	if ok {
+		entry.refs--
+		if entry.refs > 0 {
+			ok = false
+		} else {
+			delete(providers, serviceName)
+			resetGlobal(entry)
+		}
+	}
+	registryMu.Unlock()
+
+	if ok {
+		shutdownTracerProvider(entry.provider)
+	}
+}
+
+//checkRecorder 复用已有tracer时本次配置的Recorder不生效，打印警告避免span被静默丢弃
+func checkRecorder(entry *tracerEntry, o *TracerOption) {
+	if o.Recorder != nil && o.Recorder != entry.recorder {
+		fmt.Printf("tracer %s already exists, span recorder ignored, use a unique service name for each recorder\n", o.ServiceName)
+	}
+}
+
+//resetGlobal 释放的tracer仍为全局tracer时恢复为noop，其他服务设置的全局tracer保持不变，需持有registryMu
+func resetGlobal(entry *tracerEntry) {
+	if entry.tracer != nil {
+		baggageOptions.Delete(entry.tracer)
+		if Tracer == entry.tracer {
+			Tracer = opentracing.NoopTracer{}
+			Closer = noopCloser{}
+		}
+		if opentracing.GlobalTracer() == entry.tracer {
+			opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+		}
+	}
+	if entry.provider != nil {
+		baggageOptions.Delete(entry.provider)
+		if TracerProvider == entry.provider {
+			TracerProvider = nil
+		}
+		if otel.GetTracerProvider() == trace.TracerProvider(entry.provider) {
+			otel.SetTracerProvider(noop.NewTracerProvider())
+		}
+	}
+}
+
+type noopCloser struct{}
+
+func (noopCloser) Close() error {
+	return nil
+}
+
+//closeAll 不论引用计数，关闭全部tracer，用于进程退出
+func closeAll() {
+	registryMu.Lock()
+	entries := make([]*tracerEntry, 0, len(tracers)+len(providers))
+	for name, entry := range tracers {
+		entries = append(entries, entry)
+		delete(tracers, name)
+		resetGlobal(entry)
+	}
+	for name, entry := range providers {
+		entries = append(entries, entry)
+		delete(providers, name)
+		resetGlobal(entry)
+	}
+	registryMu.Unlock()
+
+	for _, entry := range entries {
+		if entry.closer != nil {
+			entry.closer.Close()
+		}
+		if entry.provider != nil {
+			shutdownTracerProvider(entry.provider)
+		}
+	}
+}
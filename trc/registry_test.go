@@ -0,0 +1,234 @@
+package trc
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestReleaseTracerResetsGlobal(t *testing.T) {
+	o, _ := newRecorderOption(t)
+	o.SkipGlobal = false
+	tracer, err := AcquireTracer(o)
+	if err != nil {
+		t.Fatalf("acquire tracer: %v", err)
+	}
+	if _, err := AcquireTracer(o); err != nil {
+		t.Fatalf("acquire tracer: %v", err)
+	}
+	if opentracing.GlobalTracer() != tracer || Tracer != tracer {
+		t.Fatalf("global tracer not set")
+	}
+
+	//仍有使用者时保持全局tracer
+	ReleaseTracer(o.ServiceName)
+	if opentracing.GlobalTracer() != tracer || Tracer != tracer {
+		t.Fatalf("global tracer reset before last release")
+	}
+
+	ReleaseTracer(o.ServiceName)
+	if _, ok := opentracing.GlobalTracer().(opentracing.NoopTracer); !ok {
+		t.Fatalf("global tracer = %T, want noop", opentracing.GlobalTracer())
+	}
+	if _, ok := Tracer.(opentracing.NoopTracer); !ok {
+		t.Fatalf("trc.Tracer = %T, want noop", Tracer)
+	}
+	if err := Closer.Close(); err != nil {
+		t.Fatalf("noop closer: %v", err)
+	}
+}
+
+func TestReleaseTracerProviderResetsGlobal(t *testing.T) {
+	o, _ := newRecorderOption(t)
+	o.SkipGlobal = false
+	tp, err := AcquireTracerProvider(o)
+	if err != nil {
+		t.Fatalf("acquire tracer provider: %v", err)
+	}
+	if otel.GetTracerProvider() != trace.TracerProvider(tp) || TracerProvider != tp {
+		t.Fatalf("global tracer provider not set")
+	}
+
+	ReleaseTracerProvider(o.ServiceName)
+	if _, ok := otel.GetTracerProvider().(noop.TracerProvider); !ok {
+		t.Fatalf("global tracer provider = %T, want noop", otel.GetTracerProvider())
+	}
+	if TracerProvider != nil {
+		t.Fatalf("trc.TracerProvider not reset")
+	}
+}
+
+func TestBaggageOptionPerTracer(t *testing.T) {
+	tenant, _ := newRecorderOption(t)
+	tenant.ServiceName += "-tenant"
+	tenant.BaggageKeys = []string{"tenant_id"}
+	tenant.BaggageMaxSize = 64
+	user, _ := newRecorderOption(t)
+	user.ServiceName += "-user"
+	user.BaggageKeys = []string{"user_id"}
+
+	tenantTracer, err := AcquireTracer(tenant)
+	if err != nil {
+		t.Fatalf("acquire tracer: %v", err)
+	}
+	defer ReleaseTracer(tenant.ServiceName)
+	//后获取的tracer不影响先获取的tracer的配置
+	userTP, err := AcquireTracerProvider(user)
+	if err != nil {
+		t.Fatalf("acquire tracer provider: %v", err)
+	}
+	defer ReleaseTracerProvider(user.ServiceName)
+
+	span := tenantTracer.StartSpan("op")
+	defer span.Finish()
+	span.SetBaggageItem("tenant_id", "t1")
+	span.SetBaggageItem("user_id", "u1")
+	ctx := opentracing.ContextWithSpan(context.Background(), span)
+	if fields := BaggageFields(ctx); len(fields) != 1 || fields[0].Key != "tenant_id" {
+		t.Fatalf("opentracing baggage fields = %v, want tenant_id", fields)
+	}
+	if limit := BaggageLimit(ctx); limit != 64 {
+		t.Fatalf("opentracing baggage limit = %d, want 64", limit)
+	}
+
+	b, _ := baggage.Parse("tenant_id=t1,user_id=u1")
+	ctx, otelSpan := userTP.Tracer("trc").Start(baggage.ContextWithBaggage(context.Background(), b), "op")
+	defer otelSpan.End()
+	if fields := BaggageFields(ctx); len(fields) != 1 || fields[0].Key != "user_id" {
+		t.Fatalf("otel baggage fields = %v, want user_id", fields)
+	}
+	if limit := BaggageLimit(ctx); limit != BaggageMaxSize() {
+		t.Fatalf("otel baggage limit = %d, want global %d", limit, BaggageMaxSize())
+	}
+}
+
+func TestTrimBaggage(t *testing.T) {
+	o, _ := newRecorderOption(t)
+	o.BaggageKeys = []string{"tenant_id"}
+	o.BaggageMaxSize = 30
+	tracer, err := AcquireTracer(o)
+	if err != nil {
+		t.Fatalf("acquire tracer: %v", err)
+	}
+	defer ReleaseTracer(o.ServiceName)
+
+	span := tracer.StartSpan("op")
+	defer span.Finish()
+	span.SetBaggageItem("a", "1234567890")
+	span.SetBaggageItem("b", "1234567890")
+	span.SetBaggageItem("tenant_id", "t1")
+	ctx := opentracing.ContextWithSpan(context.Background(), span)
+	if _, ok := CheckBaggageSize(ctx); ok {
+		t.Fatalf("baggage within limit before trim")
+	}
+
+	//tenant_id优先保留，a保留，b超出上限被删除
+	ctx, dropped := TrimBaggage(ctx)
+	if len(dropped) != 1 || dropped[0] != "b" {
+		t.Fatalf("dropped = %v, want [b]", dropped)
+	}
+	items := BaggageItems(ctx)
+	if _, ok := items["b"]; ok || items["a"] == "" || items["tenant_id"] != "t1" {
+		t.Fatalf("baggage after trim = %v", items)
+	}
+	if _, ok := CheckBaggageSize(ctx); !ok {
+		t.Fatalf("baggage exceeds limit after trim")
+	}
+
+	//otel baggage使用全局上限
+	SetBaggageMaxSize(64)
+	defer SetBaggageMaxSize(0)
+	b, err := baggage.Parse("big=" + strings.Repeat("x", 64) + ",small=1")
+	if err != nil {
+		t.Fatalf("parse baggage: %v", err)
+	}
+	ctx, dropped = TrimBaggage(baggage.ContextWithBaggage(context.Background(), b))
+	if len(dropped) != 1 || dropped[0] != "big" || GetBaggage(ctx, "small") != "1" {
+		t.Fatalf("otel dropped = %v, baggage = %v", dropped, BaggageItems(ctx))
+	}
+}
+
+func TestExemplarLabelsSampled(t *testing.T) {
+	for _, param := range []float64{1, 0} {
+		o, _ := newRecorderOption(t)
+		o.SamplerParam = param
+		tracer, err := AcquireTracer(o)
+		if err != nil {
+			t.Fatalf("acquire tracer: %v", err)
+		}
+		span := tracer.StartSpan("exemplar")
+		ctx := opentracing.ContextWithSpan(context.Background(), span)
+		labels := ExemplarLabels(ctx)
+		span.Finish()
+		ReleaseTracer(o.ServiceName)
+
+		if sampled := param == 1; sampled != (labels != nil) {
+			t.Fatalf("opentracing sampled=%v exemplar labels = %v", sampled, labels)
+		}
+	}
+
+	traceID, _ := trace.TraceIDFromHex("0102030405060708090a0b0c0d0e0f10")
+	spanID, _ := trace.SpanIDFromHex("0102030405060708")
+	for _, flags := range []trace.TraceFlags{trace.FlagsSampled, 0} {
+		sc := trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID, TraceFlags: flags})
+		labels := ExemplarLabels(trace.ContextWithSpanContext(context.Background(), sc))
+		if sampled := flags.IsSampled(); sampled != (labels != nil) {
+			t.Fatalf("otel sampled=%v exemplar labels = %v", sampled, labels)
+		}
+		if labels != nil && labels[exemplarTraceID] != traceID.String() {
+			t.Fatalf("exemplar trace_id = %s, want %s", labels[exemplarTraceID], traceID)
+		}
+	}
+}
+
+func TestExemplarLabelsReserved(t *testing.T) {
+	SetBaggageKeys("trace-id", "__name__", "tenant-id", "tenant_id")
+	defer SetBaggageKeys()
+
+	traceID, _ := trace.TraceIDFromHex("0102030405060708090a0b0c0d0e0f10")
+	spanID, _ := trace.SpanIDFromHex("0102030405060708")
+	sc := trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID, TraceFlags: trace.FlagsSampled})
+	b, err := baggage.Parse("trace-id=fake,__name__=x,tenant-id=t1,tenant_id=t2")
+	if err != nil {
+		t.Fatalf("parse baggage: %v", err)
+	}
+	ctx := baggage.ContextWithBaggage(trace.ContextWithSpanContext(context.Background(), sc), b)
+
+	//trace-id不覆盖trace_id，__开头的保留标签忽略，重名时保留先配置的key
+	labels := ExemplarLabels(ctx)
+	if labels[exemplarTraceID] != traceID.String() {
+		t.Fatalf("exemplar trace_id = %s, want %s", labels[exemplarTraceID], traceID)
+	}
+	if _, ok := labels["__name__"]; ok {
+		t.Fatalf("reserved label in exemplar: %v", labels)
+	}
+	if labels["tenant_id"] != "t1" || len(labels) != 2 {
+		t.Fatalf("exemplar labels = %v", labels)
+	}
+}
+
+func TestSetBaggageLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.WarnLevel)
+	SetLogger(zap.New(core))
+	defer SetLogger(nil)
+	SetBaggageMaxSize(16)
+	defer SetBaggageMaxSize(0)
+
+	ctx := SetBaggage(context.Background(), "big", strings.Repeat("x", 16))
+	if GetBaggage(ctx, "big") != "" {
+		t.Fatalf("baggage over limit was set")
+	}
+	entries := logs.FilterMessage("baggage size exceeds limit, item dropped").All()
+	if len(entries) != 1 || entries[0].ContextMap()["key"] != "big" {
+		t.Fatalf("warn logs = %v", logs.All())
+	}
+}
@@ -0,0 +1,213 @@
+package trc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/uber/jaeger-client-go"
+	"github.com/uber/jaeger-client-go/transport"
+)
+
+const (
+	REPORTER_UDP    = "udp"    //jaeger agent，udp上报
+	REPORTER_HTTP   = "http"   //jaeger collector，http批量上报，如http://jaeger-collector:14268/api/traces
+	REPORTER_ZIPKIN = "zipkin" //zipkin v2 json，http批量上报，如http://zipkin:9411/api/v2/spans
+
+	defaultBatchSize   = 100
+	defaultHTTPTimeout = 5 * time.Second
+)
+
+//newSender 按Reporter创建span上报方式
+func newSender(o *TracerOption) (jaeger.Transport, error) {
+	batchSize := o.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+
+	switch o.Reporter {
+	case REPORTER_HTTP:
+		opts := []transport.HTTPOption{transport.HTTPBatchSize(batchSize), transport.HTTPTimeout(defaultHTTPTimeout)}
+		if len(o.Headers) > 0 {
+			opts = append(opts, transport.HTTPHeaders(o.Headers))
+		}
+		return transport.NewHTTPTransport(o.Addr, opts...), nil
+	case REPORTER_ZIPKIN:
+		return newZipkinSender(o.Addr, o.ServiceName, batchSize, o.Headers), nil
+	default:
+		return jaeger.NewUDPTransport(o.Addr, 0)
+	}
+}
+
+//zipkinSpan zipkin v2 json格式的span
+type zipkinSpan struct {
+	TraceID        string             `json:"traceId"`
+	ID             string             `json:"id"`
+	ParentID       string             `json:"parentId,omitempty"`
+	Name           string             `json:"name"`
+	Kind           string             `json:"kind,omitempty"`
+	Timestamp      int64              `json:"timestamp"`
+	Duration       int64              `json:"duration"`
+	LocalEndpoint  *zipkinEndpoint    `json:"localEndpoint"`
+	RemoteEndpoint *zipkinEndpoint    `json:"remoteEndpoint,omitempty"`
+	Annotations    []zipkinAnnotation `json:"annotations,omitempty"`
+	Tags           map[string]string  `json:"tags,omitempty"`
+}
+
+type zipkinEndpoint struct {
+	ServiceName string `json:"serviceName,omitempty"`
+	IPv4        string `json:"ipv4,omitempty"`
+	Port        int    `json:"port,omitempty"`
+}
+
+type zipkinAnnotation struct {
+	Timestamp int64  `json:"timestamp"`
+	Value     string `json:"value"`
+}
+
+//zipkinSender 将span转换为zipkin v2 json，批量POST到zipkin
+type zipkinSender struct {
+	url         string
+	serviceName string
+	batchSize   int
+	headers     map[string]string
+	client      *http.Client
+	spans       []*zipkinSpan
+}
+
+func newZipkinSender(url string, serviceName string, batchSize int, headers map[string]string) *zipkinSender {
+	return &zipkinSender{
+		url:         url,
+		serviceName: serviceName,
+		batchSize:   batchSize,
+		headers:     headers,
+		client:      &http.Client{Timeout: defaultHTTPTimeout},
+	}
+}
+
+//Append 缓存span，达到batchSize时上报
+func (s *zipkinSender) Append(span *jaeger.Span) (int, error) {
+	s.spans = append(s.spans, s.convert(span))
+	if len(s.spans) >= s.batchSize {
+		return s.Flush()
+	}
+	return 0, nil
+}
+
+//Flush 上报缓存的span
+func (s *zipkinSender) Flush() (int, error) {
+	n := len(s.spans)
+	if n == 0 {
+		return 0, nil
+	}
+	spans := s.spans
+	s.spans = nil
+	return n, s.send(spans)
+}
+
+func (s *zipkinSender) Close() error {
+	_, err := s.Flush()
+	return err
+}
+
+func (s *zipkinSender) send(spans []*zipkinSpan) error {
+	body, err := json.Marshal(spans)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("zipkin collector %s returned status %d", s.url, resp.StatusCode)
+	}
+	return nil
+}
+
+//convert jaeger span转换为zipkin v2 span
+func (s *zipkinSender) convert(span *jaeger.Span) *zipkinSpan {
+	sc := span.SpanContext()
+	zs := &zipkinSpan{
+		TraceID:       sc.TraceID().String(),
+		ID:            sc.SpanID().String(),
+		Name:          span.OperationName(),
+		Timestamp:     span.StartTime().UnixNano() / int64(time.Microsecond),
+		Duration:      int64(span.Duration() / time.Microsecond),
+		LocalEndpoint: &zipkinEndpoint{ServiceName: s.serviceName},
+	}
+	if sc.ParentID() != 0 {
+		zs.ParentID = sc.ParentID().String()
+	}
+
+	remote := &zipkinEndpoint{}
+	tags := span.Tags()
+	if len(tags) > 0 {
+		zs.Tags = make(map[string]string, len(tags))
+	}
+	for k, v := range tags {
+		switch k {
+		case string(ext.SpanKind):
+			zs.Kind = zipkinKind(fmt.Sprint(v))
+			continue
+		case string(ext.PeerService):
+			remote.ServiceName = fmt.Sprint(v)
+		case string(ext.PeerHostIPv4):
+			remote.IPv4 = fmt.Sprint(v)
+		case string(ext.PeerPort):
+			fmt.Sscan(fmt.Sprint(v), &remote.Port)
+		}
+		zs.Tags[k] = fmt.Sprint(v)
+	}
+	if remote.ServiceName != "" || remote.IPv4 != "" {
+		zs.RemoteEndpoint = remote
+	}
+
+	for _, record := range span.Logs() {
+		fields := make([]string, 0, len(record.Fields))
+		for _, field := range record.Fields {
+			fields = append(fields, field.Key()+"="+fmt.Sprint(field.Value()))
+		}
+		sort.Strings(fields)
+		zs.Annotations = append(zs.Annotations, zipkinAnnotation{
+			Timestamp: record.Timestamp.UnixNano() / int64(time.Microsecond),
+			Value:     strings.Join(fields, " "),
+		})
+	}
+	return zs
+}
+
+//zipkinKind opentracing span.kind转换为zipkin kind
+func zipkinKind(kind string) string {
+	switch kind {
+	case string(ext.SpanKindRPCClientEnum):
+		return "CLIENT"
+	case string(ext.SpanKindRPCServerEnum):
+		return "SERVER"
+	case string(ext.SpanKindProducerEnum):
+		return "PRODUCER"
+	case string(ext.SpanKindConsumerEnum):
+		return "CONSUMER"
+	}
+	return ""
+}
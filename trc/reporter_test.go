@@ -0,0 +1,166 @@
+package trc
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/uber/jaeger-client-go/thrift"
+	"github.com/uber/jaeger-client-go/thrift-gen/jaeger"
+)
+
+//collectedRequest collector收到的上报请求
+type collectedRequest struct {
+	path          string
+	authorization string
+	contentType   string
+	body          []byte
+}
+
+//spanCollector 本地collector，记录全部上报请求
+type spanCollector struct {
+	mu       sync.Mutex
+	requests []collectedRequest
+}
+
+func (c *spanCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests = append(c.requests, collectedRequest{
+		path:          r.URL.Path,
+		authorization: r.Header.Get("Authorization"),
+		contentType:   r.Header.Get("Content-Type"),
+		body:          body,
+	})
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (c *spanCollector) received() []collectedRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]collectedRequest(nil), c.requests...)
+}
+
+//reportSpans 按配置创建tracer，上报一个客户端span及其4个子span后关闭tracer
+func reportSpans(t *testing.T, o *TracerOption) {
+	t.Helper()
+	o.SamplerType = SAMPLER_CONST
+	o.SamplerParam = 1
+	o.BatchSize = 2
+	o.FlushInterval = time.Hour //只按BatchSize及关闭时上报
+	o.Headers = map[string]string{"Authorization": "Bearer secret"}
+
+	tracer, closer, err := newTracer(o)
+	if err != nil {
+		t.Fatalf("new tracer: %v", err)
+	}
+	root := tracer.StartSpan("/order.OrderService/Get", ext.SpanKindRPCClient)
+	root.SetTag("order.id", 42)
+	root.LogKV("event", "cache miss", "key", "order-42")
+	for i := 0; i < 4; i++ {
+		tracer.StartSpan("child", opentracing.ChildOf(root.Context())).Finish()
+	}
+	root.Finish()
+	if err := closer.Close(); err != nil {
+		t.Fatalf("close tracer: %v", err)
+	}
+}
+
+func TestHTTPReporter(t *testing.T) {
+	collector := &spanCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	o := NewTracerOption(t.Name(), server.URL+"/api/traces")
+	o.Reporter = REPORTER_HTTP
+	reportSpans(t, o)
+
+	requests := collector.received()
+	sizes := make([]int, 0, len(requests))
+	for _, req := range requests {
+		if req.path != "/api/traces" || req.authorization != "Bearer secret" || req.contentType != "application/x-thrift" {
+			t.Fatalf("request path %s, Authorization %q, Content-Type %q", req.path, req.authorization, req.contentType)
+		}
+
+		batch := jaeger.NewBatch()
+		buf := thrift.NewTMemoryBuffer()
+		buf.Write(req.body)
+		if err := batch.Read(context.Background(), thrift.NewTBinaryProtocolTransport(buf)); err != nil {
+			t.Fatalf("decode thrift batch: %v", err)
+		}
+		if batch.Process.ServiceName != o.ServiceName {
+			t.Fatalf("process service name = %s, want %s", batch.Process.ServiceName, o.ServiceName)
+		}
+		sizes = append(sizes, len(batch.Spans))
+	}
+	if len(sizes) != 3 || sizes[0] != 2 || sizes[1] != 2 || sizes[2] != 1 {
+		t.Fatalf("batch sizes = %v, want [2 2 1]", sizes)
+	}
+}
+
+func TestZipkinReporter(t *testing.T) {
+	collector := &spanCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	o := NewTracerOption(t.Name(), server.URL+"/api/v2/spans")
+	o.Reporter = REPORTER_ZIPKIN
+	reportSpans(t, o)
+
+	requests := collector.received()
+	var spans []map[string]interface{}
+	sizes := make([]int, 0, len(requests))
+	for _, req := range requests {
+		if req.path != "/api/v2/spans" || req.authorization != "Bearer secret" || req.contentType != "application/json" {
+			t.Fatalf("request path %s, Authorization %q, Content-Type %q", req.path, req.authorization, req.contentType)
+		}
+		var batch []map[string]interface{}
+		if err := json.Unmarshal(req.body, &batch); err != nil {
+			t.Fatalf("decode zipkin json: %v, body %s", err, req.body)
+		}
+		sizes = append(sizes, len(batch))
+		spans = append(spans, batch...)
+	}
+	if len(sizes) != 3 || sizes[0] != 2 || sizes[1] != 2 || sizes[2] != 1 {
+		t.Fatalf("batch sizes = %v, want [2 2 1]", sizes)
+	}
+
+	//根span最后结束，位于最后一批
+	root := spans[len(spans)-1]
+	for _, key := range []string{"traceId", "id", "name", "timestamp", "duration", "localEndpoint"} {
+		if _, ok := root[key]; !ok {
+			t.Fatalf("zipkin span has no %s: %v", key, root)
+		}
+	}
+	if _, ok := root["parentId"]; ok {
+		t.Fatalf("root span has parentId: %v", root)
+	}
+	if root["name"] != "/order.OrderService/Get" || root["kind"] != "CLIENT" {
+		t.Fatalf("root name %v, kind %v", root["name"], root["kind"])
+	}
+	if endpoint, _ := root["localEndpoint"].(map[string]interface{}); endpoint["serviceName"] != o.ServiceName {
+		t.Fatalf("localEndpoint = %v", root["localEndpoint"])
+	}
+	if tags, _ := root["tags"].(map[string]interface{}); tags["order.id"] != "42" {
+		t.Fatalf("tags = %v", root["tags"])
+	}
+	if annotations, _ := root["annotations"].([]interface{}); len(annotations) != 1 {
+		t.Fatalf("annotations = %v", root["annotations"])
+	} else if value := annotations[0].(map[string]interface{})["value"]; value != "event=cache miss key=order-42" {
+		t.Fatalf("annotation value = %v", value)
+	}
+
+	for _, child := range spans[:len(spans)-1] {
+		if child["parentId"] != root["id"] || child["traceId"] != root["traceId"] {
+			t.Fatalf("child %v is not child of root %v", child, root)
+		}
+	}
+}
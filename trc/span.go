@@ -0,0 +1,48 @@
+package trc
+
+import (
+	"context"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/uber/jaeger-client-go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//TraceID 获取ctx中当前span的trace id，没有span时返回空字符串
+func TraceID(ctx context.Context) string {
+	if sc, ok := spanContext(ctx); ok {
+		return sc.TraceID().String()
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		return sc.TraceID().String()
+	}
+	return ""
+}
+
+//Sampled ctx中当前span是否被采样，未采样的span不会上报
+func Sampled(ctx context.Context) bool {
+	if sc, ok := spanContext(ctx); ok {
+		return sc.IsSampled()
+	}
+	return trace.SpanContextFromContext(ctx).IsSampled()
+}
+
+func spanContext(ctx context.Context) (jaeger.SpanContext, bool) {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return jaeger.SpanContext{}, false
+	}
+	sc, ok := span.Context().(jaeger.SpanContext)
+	return sc, ok
+}
+
+//SpanID 获取ctx中当前span的span id，没有span时返回空字符串
+func SpanID(ctx context.Context) string {
+	if sc, ok := spanContext(ctx); ok {
+		return sc.SpanID().String()
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasSpanID() {
+		return sc.SpanID().String()
+	}
+	return ""
+}
@@ -0,0 +1,69 @@
+package trc
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/opentracing/opentracing-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//SetTag 在ctx当前span上设置tag，同时支持opentracing与OpenTelemetry，没有span时忽略
+func SetTag(ctx context.Context, key string, value interface{}) {
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		span.SetTag(key, value)
+		return
+	}
+	if span := trace.SpanFromContext(ctx); span.IsRecording() {
+		span.SetAttributes(toAttribute(key, value))
+	}
+}
+
+//SetTags 在ctx当前span上设置多个tag
+func SetTags(ctx context.Context, tags map[string]interface{}) {
+	for k, v := range tags {
+		SetTag(ctx, k, v)
+	}
+}
+
+//AddEvent 在ctx当前span上记录事件，kv为交替出现的key与value，如AddEvent(ctx, "cache miss", "key", id)
+func AddEvent(ctx context.Context, name string, kv ...interface{}) {
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		span.LogKV(append([]interface{}{"event", name}, kv...)...)
+		return
+	}
+	if span := trace.SpanFromContext(ctx); span.IsRecording() {
+		attrs := make([]attribute.KeyValue, 0, len(kv)/2)
+		for i := 0; i+1 < len(kv); i += 2 {
+			attrs = append(attrs, toAttribute(fmt.Sprint(kv[i]), kv[i+1]))
+		}
+		span.AddEvent(name, trace.WithAttributes(attrs...))
+	}
+}
+
+//toAttribute 转换为OpenTelemetry属性，不支持的类型转换为字符串
+func toAttribute(key string, value interface{}) attribute.KeyValue {
+	switch v := value.(type) {
+	case string:
+		return attribute.String(key, v)
+	case bool:
+		return attribute.Bool(key, v)
+	case int:
+		return attribute.Int(key, v)
+	case int32:
+		return attribute.Int64(key, int64(v))
+	case int64:
+		return attribute.Int64(key, v)
+	case uint32:
+		return attribute.Int64(key, int64(v))
+	case float32:
+		return attribute.Float64(key, float64(v))
+	case float64:
+		return attribute.Float64(key, v)
+	case fmt.Stringer:
+		return attribute.String(key, v.String())
+	default:
+		return attribute.String(key, fmt.Sprint(v))
+	}
+}
@@ -3,56 +3,131 @@ package trc
 import (
 	"fmt"
 	"io"
-	"time"
 
 	"github.com/opentracing/opentracing-go"
 	"github.com/uber/jaeger-client-go"
 	jaegercfg "github.com/uber/jaeger-client-go/config"
+	"github.com/uber/jaeger-client-go/thrift-gen/sampling"
 )
 
 var (
-	Tracer opentracing.Tracer
-	Closer io.Closer
+	Tracer opentracing.Tracer //最近一次设置的全局tracer
+	Closer io.Closer          //Tracer对应的closer，直接关闭会影响共享该tracer的其他使用者，应使用ReleaseTracer
 )
 
 // newTracer 创建一个jaeger Tracer
-func newTracer(serviceName string, addr string) (opentracing.Tracer, io.Closer, error) {
+func newTracer(o *TracerOption) (opentracing.Tracer, io.Closer, error) {
+	if err := o.Validate(); err != nil {
+		return nil, nil, err
+	}
 
 	cfg := jaegercfg.Configuration{
-		ServiceName: serviceName,
-		Sampler: &jaegercfg.SamplerConfig{
-			Type:  jaeger.SamplerTypeConst,
-			Param: 1,
-		},
-		Reporter: &jaegercfg.ReporterConfig{
-			LogSpans:            true,
-			BufferFlushInterval: 1 * time.Second,
-		},
-	}
-
-	sender, err := jaeger.NewUDPTransport(addr, 0)
+		ServiceName: o.ServiceName,
+	}
+
+	var reporter jaeger.Reporter
+	if o.Recorder != nil {
+		reporter = o.Recorder
+	} else {
+		sender, err := newSender(o)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		reporterOpts := make([]jaeger.ReporterOption, 0)
+		if o.FlushInterval > 0 {
+			reporterOpts = append(reporterOpts, jaeger.ReporterOptions.BufferFlushInterval(o.FlushInterval))
+		}
+		if o.QueueSize > 0 {
+			reporterOpts = append(reporterOpts, jaeger.ReporterOptions.QueueSize(o.QueueSize))
+		}
+
+		reporter = jaeger.NewRemoteReporter(sender, reporterOpts...)
+		if o.LogSpans {
+			reporter = jaeger.NewCompositeReporter(jaeger.NewLoggingReporter(jaeger.StdLogger), reporter)
+		}
+	}
+
+	sampler, err := newSampler(o)
 	if err != nil {
+		reporter.Close()
 		return nil, nil, err
 	}
 
-	reporter := jaeger.NewRemoteReporter(sender)
-	tracer, closer, err := cfg.NewTracer(jaegercfg.Reporter(reporter))
+	options := []jaegercfg.Option{jaegercfg.Reporter(reporter), jaegercfg.Sampler(sampler)}
+	for k, v := range o.Tags {
+		options = append(options, jaegercfg.Tag(k, v))
+	}
+	if len(o.Propagators) > 0 {
+		p := newCompositePropagator(o.Propagators)
+		options = append(options,
+			jaegercfg.Injector(opentracing.HTTPHeaders, p), jaegercfg.Extractor(opentracing.HTTPHeaders, p),
+			jaegercfg.Injector(opentracing.TextMap, p), jaegercfg.Extractor(opentracing.TextMap, p))
+	}
+
+	tracer, closer, err := cfg.NewTracer(options...)
 	return tracer, closer, err
 }
 
+//newSampler 按配置创建采样器
+func newSampler(o *TracerOption) (jaeger.Sampler, error) {
+	switch o.SamplerType {
+	case SAMPLER_PROBABILISTIC:
+		return jaeger.NewProbabilisticSampler(o.SamplerParam)
+	case SAMPLER_RATE_LIMITING:
+		return jaeger.NewRateLimitingSampler(o.SamplerParam), nil
+	case SAMPLER_OPERATION:
+		strategies := &sampling.PerOperationSamplingStrategies{
+			DefaultSamplingProbability:       o.SamplerParam,
+			DefaultLowerBoundTracesPerSecond: o.OperationLowerBound,
+		}
+		for op, rate := range o.OperationSampling {
+			strategies.PerOperationStrategies = append(strategies.PerOperationStrategies, &sampling.OperationSamplingStrategy{
+				Operation:             op,
+				ProbabilisticSampling: &sampling.ProbabilisticSamplingStrategy{SamplingRate: rate},
+			})
+		}
+		return jaeger.NewPerOperationSampler(jaeger.PerOperationSamplerParams{
+			MaxOperations: o.MaxOperations,
+			Strategies:    strategies,
+		}), nil
+	case SAMPLER_REMOTE:
+		initial, err := jaeger.NewProbabilisticSampler(o.SamplerParam)
+		if err != nil {
+			return nil, err
+		}
+		opts := []jaeger.SamplerOption{
+			jaeger.SamplerOptions.InitialSampler(initial),
+			jaeger.SamplerOptions.SamplingServerURL(o.samplingServerURL()),
+		}
+		if o.SamplingRefreshInterval > 0 {
+			opts = append(opts, jaeger.SamplerOptions.SamplingRefreshInterval(o.SamplingRefreshInterval))
+		}
+		if o.MaxOperations > 0 {
+			opts = append(opts, jaeger.SamplerOptions.MaxOperations(o.MaxOperations))
+		}
+		return jaeger.NewRemotelyControlledSampler(o.ServiceName, opts...), nil
+	default:
+		return jaeger.NewConstSampler(o.SamplerParam != 0), nil
+	}
+}
+
+//InitTracer 使用缺省配置及环境变量创建tracer
 func InitTracer(serviceName string, jaegerAddr string) (opentracing.Tracer, error) {
-	var err error
-	Tracer, Closer, err = newTracer(serviceName, jaegerAddr)
+	return InitTracerWithOption(NewTracerOption(serviceName, jaegerAddr))
+}
+
+//InitTracerWithOption 按配置获取tracer，同一服务名称共享同一个tracer，见AcquireTracer
+func InitTracerWithOption(o *TracerOption) (opentracing.Tracer, error) {
+	tracer, err := AcquireTracer(o)
 	if err != nil {
 		fmt.Printf("create tracer fail! error<%v>\n", err)
 		return nil, err
 	}
-	opentracing.SetGlobalTracer(Tracer)
-	return Tracer, nil
+	return tracer, nil
 }
 
+//UnInit 关闭全部tracer，用于进程退出
 func UnInit() {
-	if Closer != nil {
-		Closer.Close()
-	}
+	closeAll()
 }
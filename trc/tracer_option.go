@@ -0,0 +1,266 @@
+package trc
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ENV_TRC_SAMPLER_TYPE       = "env_trc_sampler_type"       //采样方式: const|probabilistic|ratelimiting|operation|remote
+	ENV_TRC_SAMPLER_PARAM      = "env_trc_sampler_param"      //采样参数
+	ENV_TRC_SAMPLER_URL        = "env_trc_sampler_url"        //remote采样策略地址，如http://127.0.0.1:5778/sampling
+	ENV_TRC_SAMPLER_OPERATIONS = "env_trc_sampler_operations" //operation采样时各方法的采样概率，如/order.OrderService/GetOrderInfo=0.5
+	ENV_TRC_LOG_SPANS          = "env_trc_log_spans"          //是否打印上报的span on|off
+	ENV_TRC_FLUSH_INTERVAL     = "env_trc_flush_interval"     //span上报间隔，单位毫秒
+	ENV_TRC_QUEUE_SIZE         = "env_trc_queue_size"         //span上报队列长度
+	ENV_TRC_TAGS               = "env_trc_tags"               //tracer公共tag，如env=prod,zone=sz
+	ENV_TRC_OTLP_PROTOCOL      = "env_trc_otlp_protocol"      //otel上报协议: grpc|http
+	ENV_TRC_OTLP_TLS           = "env_trc_otlp_tls"           //otel上报是否使用tls on|off
+	ENV_TRC_OTLP_HEADERS       = "env_trc_otlp_headers"       //otel上报附加的header，如authorization=Bearer xxx
+	ENV_TRC_PROPAGATORS        = "env_trc_propagators"        //调用链传递格式，多个以逗号分隔: jaeger,b3,b3multi,w3c
+	ENV_TRC_REPORTER           = "env_trc_reporter"           //span上报方式: udp|http|zipkin
+	ENV_TRC_BATCH_SIZE         = "env_trc_batch_size"         //http|zipkin每次上报的span数量
+	ENV_TRC_HEADERS            = "env_trc_headers"            //http|zipkin上报附加的header，如authorization=Bearer xxx
+	ENV_TRC_BAGGAGE_KEYS       = "env_trc_baggage_keys"       //写入日志字段与exemplar的baggage key，多个以逗号分隔
+	ENV_TRC_BAGGAGE_MAX_SIZE   = "env_trc_baggage_max_size"   //baggage上限，单位字节
+)
+
+const (
+	SAMPLER_CONST         = "const"         //全部采样(Param为1)或全部不采样(Param为0)
+	SAMPLER_PROBABILISTIC = "probabilistic" //按概率采样，Param为0~1
+	SAMPLER_RATE_LIMITING = "ratelimiting"  //限速采样，Param为每秒最多采样数
+	SAMPLER_OPERATION     = "operation"     //按方法设置采样概率，Param为未配置方法的缺省概率
+	SAMPLER_REMOTE        = "remote"        //从jaeger agent拉取采样策略，Param为拉取成功前的采样概率
+
+	defaultSamplingPort = "5778"
+)
+
+//TracerOption 调用链配置
+type TracerOption struct {
+	ServiceName string //服务名称
+	Addr        string //jaeger agent地址，如127.0.0.1:6831；http|zipkin上报及otel时为collector地址
+
+	SamplerType             string             //采样方式: const|probabilistic|ratelimiting|operation|remote，缺省为const
+	SamplerParam            float64            //采样参数，含义见SAMPLER_*
+	SamplingServerURL       string             //remote采样策略地址，缺省为http://{agent host}:5778/sampling
+	SamplingRefreshInterval time.Duration      //remote采样策略刷新间隔，缺省1分钟
+	OperationSampling       map[string]float64 //operation采样时各方法(grpc全方法名)的采样概率
+	OperationLowerBound     float64            //operation采样时每个方法每秒至少采样的数量
+	MaxOperations           int                //operation与remote采样时最多单独统计的方法数
+
+	Reporter  string            //span上报方式: udp|http|zipkin，缺省为udp，otel时不使用
+	BatchSize int               //http|zipkin每次上报的span数量，缺省100
+	Headers   map[string]string //http|zipkin上报附加的header，如认证信息
+
+	LogSpans      bool              //是否打印上报的span，用于调试
+	FlushInterval time.Duration     //span上报间隔
+	QueueSize     int               //span上报队列长度，队列满时丢弃
+	Tags          map[string]string //tracer公共tag，附加在每个span上
+
+	OTLPProtocol string            //otel上报协议: grpc|http，缺省为grpc
+	OTLPTLS      bool              //otel上报是否使用tls
+	OTLPHeaders  map[string]string //otel上报附加的header，如认证信息
+
+	//调用链传递格式: jaeger|b3|b3multi|w3c，注入时写入全部格式，提取时按顺序使用第一个有效的格式
+	//缺省opentracing为jaeger，otel为w3c
+	Propagators []string
+
+	BaggageKeys    []string //写入日志字段与prometheus exemplar的baggage key，如tenant_id、user_id
+	BaggageMaxSize int      //baggage上限(key与value的字节数之和)，缺省8192
+
+	SkipGlobal bool //不设置为全局tracer(opentracing.GlobalTracer、otel全局provider)，同一进程有多个服务时使用
+
+	Recorder *SpanRecorder //span记录到内存而不上报，用于测试，设置后忽略Addr及上报配置
+}
+
+//NewTracerOption 缺省全部采样，环境变量中的配置覆盖缺省值
+func NewTracerOption(serviceName string, addr string) *TracerOption {
+	o := &TracerOption{
+		ServiceName:   serviceName,
+		Addr:          addr,
+		SamplerType:   SAMPLER_CONST,
+		SamplerParam:  1,
+		FlushInterval: time.Second,
+	}
+	o.Init()
+	return o
+}
+
+//Init 读取环境变量中的调用链配置
+func (o *TracerOption) Init() {
+	if st := os.Getenv(ENV_TRC_SAMPLER_TYPE); st != "" {
+		o.SamplerType = strings.ToLower(st)
+	}
+	if sp := os.Getenv(ENV_TRC_SAMPLER_PARAM); sp != "" {
+		if v, err := strconv.ParseFloat(sp, 64); err == nil {
+			o.SamplerParam = v
+		} else {
+			fmt.Printf("invalid %s: %v\n", ENV_TRC_SAMPLER_PARAM, sp)
+		}
+	}
+	if url := os.Getenv(ENV_TRC_SAMPLER_URL); url != "" {
+		o.SamplingServerURL = url
+	}
+	if ops := os.Getenv(ENV_TRC_SAMPLER_OPERATIONS); ops != "" {
+		o.OperationSampling = make(map[string]float64)
+		for k, v := range parseKV(ops) {
+			rate, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				fmt.Printf("invalid %s: %v=%v\n", ENV_TRC_SAMPLER_OPERATIONS, k, v)
+				continue
+			}
+			o.OperationSampling[k] = rate
+		}
+	}
+
+	switch strings.ToLower(os.Getenv(ENV_TRC_LOG_SPANS)) {
+	case "on", "true":
+		o.LogSpans = true
+	case "off", "false":
+		o.LogSpans = false
+	}
+
+	if fi := os.Getenv(ENV_TRC_FLUSH_INTERVAL); fi != "" {
+		if ms, err := strconv.Atoi(fi); err == nil {
+			o.FlushInterval = time.Duration(ms) * time.Millisecond
+		}
+	}
+	if qs := os.Getenv(ENV_TRC_QUEUE_SIZE); qs != "" {
+		if size, err := strconv.Atoi(qs); err == nil {
+			o.QueueSize = size
+		}
+	}
+	if tags := os.Getenv(ENV_TRC_TAGS); tags != "" {
+		if o.Tags == nil {
+			o.Tags = make(map[string]string)
+		}
+		for k, v := range parseKV(tags) {
+			o.Tags[k] = v
+		}
+	}
+
+	if reporter := os.Getenv(ENV_TRC_REPORTER); reporter != "" {
+		o.Reporter = strings.ToLower(reporter)
+	}
+	if bs := os.Getenv(ENV_TRC_BATCH_SIZE); bs != "" {
+		if size, err := strconv.Atoi(bs); err == nil {
+			o.BatchSize = size
+		}
+	}
+	if headers := os.Getenv(ENV_TRC_HEADERS); headers != "" {
+		if o.Headers == nil {
+			o.Headers = make(map[string]string)
+		}
+		for k, v := range parseKV(headers) {
+			o.Headers[k] = v
+		}
+	}
+
+	if protocol := os.Getenv(ENV_TRC_OTLP_PROTOCOL); protocol != "" {
+		o.OTLPProtocol = strings.ToLower(protocol)
+	}
+	switch strings.ToLower(os.Getenv(ENV_TRC_OTLP_TLS)) {
+	case "on", "true":
+		o.OTLPTLS = true
+	case "off", "false":
+		o.OTLPTLS = false
+	}
+	if propagators := os.Getenv(ENV_TRC_PROPAGATORS); propagators != "" {
+		o.Propagators = strings.Split(propagators, ",")
+	}
+	if headers := os.Getenv(ENV_TRC_OTLP_HEADERS); headers != "" {
+		if o.OTLPHeaders == nil {
+			o.OTLPHeaders = make(map[string]string)
+		}
+		for k, v := range parseKV(headers) {
+			o.OTLPHeaders[k] = v
+		}
+	}
+
+	if keys := os.Getenv(ENV_TRC_BAGGAGE_KEYS); keys != "" {
+		o.BaggageKeys = strings.Split(keys, ",")
+	}
+	if bs := os.Getenv(ENV_TRC_BAGGAGE_MAX_SIZE); bs != "" {
+		if size, err := strconv.Atoi(bs); err == nil {
+			o.BaggageMaxSize = size
+		}
+	}
+}
+
+//Validate 校验调用链配置
+func (o *TracerOption) Validate() error {
+	switch o.SamplerType {
+	case "", SAMPLER_CONST:
+	case SAMPLER_PROBABILISTIC, SAMPLER_OPERATION, SAMPLER_REMOTE:
+		if o.SamplerParam < 0 || o.SamplerParam > 1 {
+			return fmt.Errorf("tracer config: %s SamplerParam must be between 0 and 1, got %v", o.SamplerType, o.SamplerParam)
+		}
+	case SAMPLER_RATE_LIMITING:
+		if o.SamplerParam < 0 {
+			return fmt.Errorf("tracer config: ratelimiting SamplerParam must not be negative, got %v", o.SamplerParam)
+		}
+	default:
+		return fmt.Errorf("tracer config: invalid SamplerType %q, expect const, probabilistic, ratelimiting, operation or remote", o.SamplerType)
+	}
+
+	for op, rate := range o.OperationSampling {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("tracer config: OperationSampling[%s] must be between 0 and 1, got %v", op, rate)
+		}
+	}
+	switch o.Reporter {
+	case "", REPORTER_UDP:
+	case REPORTER_HTTP, REPORTER_ZIPKIN:
+		if o.Recorder == nil && !strings.HasPrefix(o.Addr, "http://") && !strings.HasPrefix(o.Addr, "https://") {
+			return fmt.Errorf("tracer config: %s reporter Addr must be a http url, got %q", o.Reporter, o.Addr)
+		}
+	default:
+		return fmt.Errorf("tracer config: invalid Reporter %q, expect udp, http or zipkin", o.Reporter)
+	}
+	switch o.OTLPProtocol {
+	case "", OTLP_PROTOCOL_GRPC, OTLP_PROTOCOL_HTTP:
+	default:
+		return fmt.Errorf("tracer config: invalid OTLPProtocol %q, expect grpc or http", o.OTLPProtocol)
+	}
+	if err := validatePropagators(o.Propagators); err != nil {
+		return err
+	}
+	if o.QueueSize < 0 || o.FlushInterval < 0 || o.MaxOperations < 0 || o.OperationLowerBound < 0 || o.BatchSize < 0 {
+		return fmt.Errorf("tracer config: QueueSize, FlushInterval, MaxOperations, OperationLowerBound and BatchSize must not be negative")
+	}
+	return nil
+}
+
+//samplingServerURL remote采样策略地址，未配置时使用jaeger agent所在主机的5778端口
+func (o *TracerOption) samplingServerURL() string {
+	if o.SamplingServerURL != "" {
+		return o.SamplingServerURL
+	}
+
+	host, _, err := net.SplitHostPort(o.Addr)
+	if err != nil || host == "" {
+		host = "127.0.0.1"
+	}
+	return "http://" + net.JoinHostPort(host, defaultSamplingPort) + "/sampling"
+}
+
+//parseKV 解析以逗号分隔的k=v列表
+func parseKV(s string) map[string]string {
+	result := make(map[string]string)
+	for _, kv := range strings.Split(s, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		i := strings.Index(kv, "=")
+		if i <= 0 {
+			continue
+		}
+		result[strings.TrimSpace(kv[:i])] = strings.TrimSpace(kv[i+1:])
+	}
+	return result
+}